@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// archive layout:
+//
+//	<cert path>/archive/<version>/<each managed file>
+//	<cert path>/archive/<version>/metadata.json
+//	<cert path>/archive/hold.json (only exists after a rollback)
+const (
+	archiveDirName          = "archive"
+	archiveMetadataFilename = "metadata.json"
+	archiveHoldFilename     = "hold.json"
+)
+
+// archiveMetadata is the information about an archived version of the files
+type archiveMetadata struct {
+	Version           int       `json:"version"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	Serial            string    `json:"serial"`
+	NotAfter          time.Time `json:"not_after"`
+	InstalledAt       time.Time `json:"installed_at"`
+	Files             []string  `json:"files"`
+}
+
+// archiveHold records a cert that was rolled back, so that it is not written to disk
+// again (it is only cleared when a different cert is received)
+type archiveHold struct {
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	RolledBackAt      time.Time `json:"rolled_back_at"`
+}
+
+// certFingerprint returns the hex encoded sha256 fingerprint of the leaf cert in
+// certPem
+func certFingerprint(certPem []byte) (string, error) {
+	cert, _, err := certPemToCerts(certPem)
+	if err != nil {
+		return "", err
+	}
+
+	fp := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fp[:]), nil
+}
+
+// archivePath returns the path of the archive dir
+func (app *app) archivePath() string {
+	return filepath.Join(app.cfg.CertStoragePath, archiveDirName)
+}
+
+// archiveVersions returns the metadata of all of the archived versions, sorted from
+// oldest to newest
+func (app *app) archiveVersions() ([]archiveMetadata, error) {
+	entries, err := os.ReadDir(app.archivePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	versions := []archiveMetadata{}
+	for _, entry := range entries {
+		// only numbered dirs are versions
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+
+		metaBytes, err := os.ReadFile(filepath.Join(app.archivePath(), entry.Name(), archiveMetadataFilename))
+		if err != nil {
+			app.logger.Errorf("archive version %s metadata could not be read (%s), skipping it", entry.Name(), err)
+			continue
+		}
+
+		meta := archiveMetadata{}
+		err = json.Unmarshal(metaBytes, &meta)
+		if err != nil {
+			app.logger.Errorf("archive version %s metadata could not be parsed (%s), skipping it", entry.Name(), err)
+			continue
+		}
+
+		versions = append(versions, meta)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+
+	return versions, nil
+}
+
+// archiveCurrentFiles copies the current set of managed files into a new archive
+// version and then prunes versions beyond the configured count. Additional output files
+// that don't exist yet (e.g. newly enabled) are skipped. If the newest archived version
+// is already the current cert, nothing is done.
+func (app *app) archiveCurrentFiles() error {
+	certPem, _, err := readManagedFile(app.cfg.CertStoragePath, "certchain.pem")
+	if err != nil {
+		return err
+	}
+	cert, _, err := certPemToCerts(certPem)
+	if err != nil {
+		return err
+	}
+	fingerprint, _ := certFingerprint(certPem)
+
+	versions, err := app.archiveVersions()
+	if err != nil {
+		return err
+	}
+
+	// skip if newest version is this cert
+	nextVersion := 1
+	if len(versions) > 0 {
+		newest := versions[len(versions)-1]
+		if newest.FingerprintSHA256 == fingerprint {
+			app.logger.Debugf("archive: current cert already archived as version %d", newest.Version)
+			return nil
+		}
+		nextVersion = newest.Version + 1
+	}
+
+	// make version dir
+	versionDir := filepath.Join(app.archivePath(), strconv.Itoa(nextVersion))
+	err = os.MkdirAll(versionDir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make archive dir (%s)", err)
+	}
+
+	// copy files
+	meta := archiveMetadata{
+		Version:           nextVersion,
+		FingerprintSHA256: fingerprint,
+		Serial:            cert.SerialNumber.Text(16),
+		NotAfter:          cert.NotAfter,
+		InstalledAt:       time.Now(),
+	}
+	for _, name := range app.managedFilenames() {
+		data, perm, err := readManagedFile(app.cfg.CertStoragePath, name)
+		if errors.Is(err, os.ErrNotExist) && name != "key.pem" && name != "certchain.pem" {
+			continue
+		} else if err != nil {
+			_ = os.RemoveAll(versionDir)
+			return err
+		}
+
+		err = writeFileAtomic(filepath.Join(versionDir, name), data, perm)
+		if err != nil {
+			_ = os.RemoveAll(versionDir)
+			return fmt.Errorf("failed to archive %s (%s)", name, err)
+		}
+		meta.Files = append(meta.Files, name)
+	}
+
+	// metadata is written last, a version without it is ignored
+	metaBytes, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		_ = os.RemoveAll(versionDir)
+		return err
+	}
+	err = writeFileAtomic(filepath.Join(versionDir, archiveMetadataFilename), metaBytes, 0600)
+	if err != nil {
+		_ = os.RemoveAll(versionDir)
+		return fmt.Errorf("failed to write archive metadata (%s)", err)
+	}
+
+	app.logger.Infof("archive: archived current files as version %d (serial %s)", meta.Version, meta.Serial)
+
+	// prune old versions
+	versions = append(versions, meta)
+	for len(versions) > app.cfg.ArchiveCount {
+		err = os.RemoveAll(filepath.Join(app.archivePath(), strconv.Itoa(versions[0].Version)))
+		if err != nil {
+			app.logger.Errorf("archive: failed to remove old version %d (%s)", versions[0].Version, err)
+		} else {
+			app.logger.Debugf("archive: removed old version %d", versions[0].Version)
+		}
+		versions = versions[1:]
+	}
+
+	return nil
+}
+
+// rollbackToArchiveVersion reinstates the files of the specified archive version and
+// then restarts docker containers (if configured). The cert that was rolled back is put
+// on hold so it won't be written to disk again until a different cert is received.
+func (app *app) rollbackToArchiveVersion(version int) error {
+	versions, err := app.archiveVersions()
+	if err != nil {
+		return fmt.Errorf("failed to read archive (%s)", err)
+	}
+
+	var meta *archiveMetadata
+	for i := range versions {
+		if versions[i].Version == version {
+			meta = &versions[i]
+			break
+		}
+	}
+	if meta == nil {
+		return fmt.Errorf("archive version %d does not exist", version)
+	}
+
+	// load archived files
+	versionDir := filepath.Join(app.archivePath(), strconv.Itoa(meta.Version))
+	files := []fileWrite{}
+	for _, name := range meta.Files {
+		data, perm, err := readManagedFile(versionDir, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, fileWrite{filename: name, data: data, perm: perm})
+	}
+
+	// hold the cert currently on disk (if it isn't the one being restored)
+	currentCertPem, _, err := readManagedFile(app.cfg.CertStoragePath, "certchain.pem")
+	if err == nil {
+		currentFingerprint, err := certFingerprint(currentCertPem)
+		if err == nil && currentFingerprint != meta.FingerprintSHA256 {
+			err = app.setArchiveHold(currentFingerprint)
+			if err != nil {
+				return fmt.Errorf("failed to record hold for rolled back cert (%s)", err)
+			}
+		}
+	}
+
+	// archived cert (for hooks)
+	var archivedCertPem []byte
+	for _, file := range files {
+		if file.filename == "certchain.pem" {
+			archivedCertPem = file.data
+		}
+	}
+
+	// run pre-write hooks, a failure aborts the rollback
+	if len(app.preWriteHooks) > 0 {
+		env := app.execHookEnv(execHookStagePreWrite, archivedCertPem, meta.Files)
+		if app.runExecHooks(execHookStagePreWrite, app.preWriteHooks, env, true) {
+			return errors.New("a pre-write hook failed")
+		}
+	}
+
+	// outputs that weren't archived with this version (e.g. enabled since) would be left
+	// with a different cert than the reinstated files, so they are removed
+	for _, name := range app.managedFilenames() {
+		if slices.Contains(meta.Files, name) {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(app.cfg.CertStoragePath, name))
+		if err == nil {
+			app.logger.Warnf("rollback: removed %s, it wasn't archived with version %d", name, meta.Version)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s, it wasn't archived with version %d (%s)", name, meta.Version, err)
+		}
+	}
+
+	// write
+	_, failedAny := app.writeFiles(files)
+	if failedAny {
+		return errors.New("failed to write one or more archived files")
+	}
+	app.logger.Infof("rollback: reinstated archive version %d (serial %s, expires %s)", meta.Version, meta.Serial, meta.NotAfter)
+
+	// run post-write hooks
+	if len(app.postWriteHooks) > 0 {
+		env := app.execHookEnv(execHookStagePostWrite, archivedCertPem, meta.Files)
+		app.runExecHooks(execHookStagePostWrite, app.postWriteHooks, env, false)
+	}
+
+	// tell services about the reinstated files
+	if len(app.postWriteActions) > 0 {
+		keyPem, _, err := readManagedFile(app.cfg.CertStoragePath, "key.pem")
+		var certPem []byte
+		if err == nil {
+			certPem, _, err = readManagedFile(app.cfg.CertStoragePath, "certchain.pem")
+		}
+		if err != nil {
+			app.logger.Errorf("rollback: failed to read reinstated key/cert for post write actions (%s)", err)
+		} else {
+			app.runPostWriteActions(keyPem, certPem)
+		}
+	}
+
+	// reinstate files copied into containers
+	for _, backend := range app.outputBackends {
+		dockerCopy, isDockerCopy := backend.(*dockerCopyOutput)
+		if !isDockerCopy {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(app.shutdownContext, outputBackendTimeout)
+		err = dockerCopy.copyArchivedFiles(ctx, files)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to reinstate %s (%s)", dockerCopy.name(), err)
+		}
+	}
+
+	// restart containers
+	if app.dockerUpdatesConfigured() {
+		app.logger.Info("rollback: updating docker containers")
+		err = app.restartOrStopDockerContainers()
+		if err != nil {
+			return fmt.Errorf("files were reinstated but docker containers failed to update (%s)", err)
+		}
+	}
+
+	return nil
+}
+
+// rollbackToPreviousVersion reinstates the newest archived version that is not the
+// specified cert (i.e. the files the specified cert replaced)
+func (app *app) rollbackToPreviousVersion(certPem []byte) error {
+	fingerprint, err := certFingerprint(certPem)
+	if err != nil {
+		return err
+	}
+
+	versions, err := app.archiveVersions()
+	if err != nil {
+		return fmt.Errorf("failed to read archive (%s)", err)
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].FingerprintSHA256 != fingerprint {
+			return app.rollbackToArchiveVersion(versions[i].Version)
+		}
+	}
+
+	return errors.New("no previous version is archived")
+}
+
+// setArchiveHold records the cert fingerprint that should not be written to disk
+func (app *app) setArchiveHold(fingerprint string) error {
+	err := os.MkdirAll(app.archivePath(), 0700)
+	if err != nil {
+		return err
+	}
+
+	holdBytes, err := json.MarshalIndent(archiveHold{FingerprintSHA256: fingerprint, RolledBackAt: time.Now()}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(app.archivePath(), archiveHoldFilename), holdBytes, 0600)
+}
+
+// certIsHeld returns true if the specified cert was rolled back and should not be
+// written to disk. If there is a hold for a different cert, the hold is cleared since a
+// new cert has been received.
+func (app *app) certIsHeld(certPem []byte) bool {
+	holdPath := filepath.Join(app.archivePath(), archiveHoldFilename)
+
+	holdBytes, err := os.ReadFile(holdPath)
+	if err != nil {
+		// no hold
+		return false
+	}
+
+	hold := archiveHold{}
+	err = json.Unmarshal(holdBytes, &hold)
+	if err != nil {
+		app.logger.Errorf("archive: failed to parse hold file (%s), ignoring it", err)
+		return false
+	}
+
+	fingerprint, err := certFingerprint(certPem)
+	if err != nil {
+		return false
+	}
+
+	if fingerprint == hold.FingerprintSHA256 {
+		return true
+	}
+
+	// different cert, clear the hold
+	err = os.Remove(holdPath)
+	if err != nil {
+		app.logger.Errorf("archive: failed to remove old hold file (%s)", err)
+	} else {
+		app.logger.Info("archive: new cert received, cleared hold on previously rolled back cert")
+	}
+
+	return false
+}
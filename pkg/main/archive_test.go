@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testWriteCertFiles writes key.pem and certchain.pem to the app's storage path
+func testWriteCertFiles(t *testing.T, app *app, keyPem, certPem []byte, keyPerm os.FileMode) {
+	t.Helper()
+
+	err := writeFileAtomic(filepath.Join(app.cfg.CertStoragePath, "key.pem"), keyPem, keyPerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeFileAtomic(filepath.Join(app.cfg.CertStoragePath, "certchain.pem"), certPem, app.cfg.CertPermissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testArchiveVersionNumbers returns the version numbers in the archive
+func testArchiveVersionNumbers(t *testing.T, app *app) []int {
+	t.Helper()
+
+	versions, err := app.archiveVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := []int{}
+	for _, version := range versions {
+		numbers = append(numbers, version.Version)
+	}
+	return numbers
+}
+
+func TestArchiveCurrentFiles(t *testing.T) {
+	app := testApp(t)
+	app.cfg.ArchiveCount = 2
+
+	for serial := int64(1); serial <= 3; serial++ {
+		keyPem, certPem := testKeyCert(t, serial)
+		testWriteCertFiles(t, app, keyPem, certPem, app.cfg.KeyPermissions)
+
+		err := app.archiveCurrentFiles()
+		if err != nil {
+			t.Fatalf("archiveCurrentFiles failed: %s", err)
+		}
+
+		// same cert again is skipped
+		err = app.archiveCurrentFiles()
+		if err != nil {
+			t.Fatalf("archiveCurrentFiles failed: %s", err)
+		}
+
+		// archived bytes
+		versionDir := filepath.Join(app.archivePath(), strconv.FormatInt(serial, 10))
+		archivedKey, _ := os.ReadFile(filepath.Join(versionDir, "key.pem"))
+		archivedCert, _ := os.ReadFile(filepath.Join(versionDir, "certchain.pem"))
+		if !bytes.Equal(archivedKey, keyPem) || !bytes.Equal(archivedCert, certPem) {
+			t.Fatalf("version %d does not contain the current files", serial)
+		}
+	}
+
+	// pruned to the archive count
+	got := testArchiveVersionNumbers(t, app)
+	if len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("archive versions = %v, want [2 3]", got)
+	}
+	_, err := os.Stat(filepath.Join(app.archivePath(), "1"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("pruned version 1 dir still exists")
+	}
+
+	versions, _ := app.archiveVersions()
+	if versions[1].Serial != "3" || len(versions[1].Files) != 2 {
+		t.Errorf("version 3 metadata = %+v", versions[1])
+	}
+
+	// a version dir without metadata (e.g. archiving was interrupted) is ignored
+	err = os.MkdirAll(filepath.Join(app.archivePath(), "9"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = testArchiveVersionNumbers(t, app)
+	if len(got) != 2 || got[1] != 3 {
+		t.Errorf("archive versions = %v, want [2 3]", got)
+	}
+}
+
+func TestRollbackToArchiveVersion(t *testing.T) {
+	t.Run("files", func(t *testing.T) {
+		testRollbackToArchiveVersion(t, false)
+	})
+	t.Run("versioned set", func(t *testing.T) {
+		testRollbackToArchiveVersion(t, true)
+	})
+}
+
+func testRollbackToArchiveVersion(t *testing.T, fileVersionedSet bool) {
+	app := testApp(t)
+	app.cfg.ArchiveCount = 2
+	app.cfg.FileVersionedSet = fileVersionedSet
+
+	// version 1, with a key perm that differs from the config
+	oldKeyPem, oldCertPem := testKeyCert(t, 1)
+	testWriteCertFiles(t, app, oldKeyPem, oldCertPem, 0640)
+	err := app.archiveCurrentFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// current files, plus an output enabled since version 1 was archived
+	newKeyPem, newCertPem := testKeyCert(t, 2)
+	testWriteCertFiles(t, app, newKeyPem, newCertPem, app.cfg.KeyPermissions)
+	app.cfg.PfxCreate = true
+	app.cfg.PfxFilename = "key.pfx"
+	pfxPath := filepath.Join(app.cfg.CertStoragePath, app.cfg.PfxFilename)
+	err = os.WriteFile(pfxPath, []byte("pfx of the new cert"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.rollbackToArchiveVersion(1)
+	if err != nil {
+		t.Fatalf("rollback failed: %s", err)
+	}
+
+	// bytes and perms restored
+	keyPem, keyPerm, _ := readManagedFile(app.cfg.CertStoragePath, "key.pem")
+	certPem, _, _ := readManagedFile(app.cfg.CertStoragePath, "certchain.pem")
+	if !bytes.Equal(keyPem, oldKeyPem) || !bytes.Equal(certPem, oldCertPem) {
+		t.Error("rollback did not reinstate the archived key/cert")
+	}
+	if keyPerm != 0640 {
+		t.Errorf("key.pem perm = %o, want 640", keyPerm)
+	}
+
+	// output that wasn't archived is removed (instead of left with the new cert)
+	_, err = os.Stat(pfxPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("output that wasn't archived with the version was not removed")
+	}
+
+	// rolled back cert is held
+	if !app.certIsHeld(newCertPem) {
+		t.Error("rolled back cert is not held")
+	}
+
+	// a different cert clears the hold
+	_, newerCertPem := testKeyCert(t, 3)
+	if app.certIsHeld(newerCertPem) {
+		t.Error("a different cert is held")
+	}
+	if app.certIsHeld(newCertPem) {
+		t.Error("hold was not cleared by a different cert")
+	}
+
+	// version that doesn't exist
+	err = app.rollbackToArchiveVersion(5)
+	if err == nil {
+		t.Error("rollback to a version that doesn't exist did not fail")
+	}
+}
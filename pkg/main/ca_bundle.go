@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// pemToCerts returns all of the certificates in pemBytes
+func pemToCerts(pemBytes []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+
+		// skip anything that isn't a cert
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// makeCABundle returns a pem bundle of the CA certificates that issued the cert in
+// certPem (i.e. the chain without the leaf). Any certs in rootPem are appended and, if
+// rootFromSystem, the root that the chain verifies to in the system pool is appended.
+func makeCABundle(certPem []byte, rootPem []byte, rootFromSystem bool) (bundlePem []byte, err error) {
+	// get cert and chain
+	cert, certChain, err := certPemToCerts(certPem)
+	if err != nil {
+		return nil, err
+	}
+	bundle := certChain
+
+	// isInBundle returns true if c is already in the bundle
+	isInBundle := func(c *x509.Certificate) bool {
+		for i := range bundle {
+			if bytes.Equal(bundle[i].Raw, c.Raw) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// configured root(s)
+	if len(rootPem) > 0 {
+		roots, err := pemToCerts(rootPem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse root cert(s) (%s)", err)
+		}
+
+		for i := range roots {
+			if !isInBundle(roots[i]) {
+				bundle = append(bundle, roots[i])
+			}
+		}
+	}
+
+	// root from system pool
+	if rootFromSystem {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load system cert pool (%s)", err)
+		}
+
+		intermediates := x509.NewCertPool()
+		for i := range certChain {
+			intermediates.AddCert(certChain[i])
+		}
+
+		chains, err := cert.Verify(x509.VerifyOptions{
+			Roots:         systemPool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve root from system cert pool (%s)", err)
+		}
+
+		// root is the last cert of the (first) verified chain
+		root := chains[0][len(chains[0])-1]
+		if !isInBundle(root) {
+			bundle = append(bundle, root)
+		}
+	}
+
+	if len(bundle) == 0 {
+		return nil, errors.New("cert pem does not contain a chain and no root was configured")
+	}
+
+	// encode
+	for i := range bundle {
+		bundlePem = append(bundlePem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: bundle[i].Raw})...)
+	}
+
+	return bundlePem, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// caddyTLSAppPath is the admin api config path of the tls app
+const caddyTLSAppPath = "/config/apps/tls"
+
+// caddyLoadPem is an entry of caddy's tls.certificates.load_pem
+type caddyLoadPem struct {
+	Certificate string   `json:"certificate"`
+	Key         string   `json:"key"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// caddyAction is a post write action that loads the key/cert into caddy using its admin
+// api. The cert is put in tls.certificates.load_pem (tagged so it can be found and
+// replaced next time), either by changing just that section of caddy's running config or,
+// if a config file is specified, by loading that file (with the cert added) using /load.
+type caddyAction struct {
+	baseURL    string
+	httpClient *http.Client
+	// tag identifies the load_pem entry that is managed by the client
+	tag string
+	// configFile, if set, is the caddy json config to /load (instead of patching)
+	configFile string
+	keyFormat  string
+}
+
+// makeCaddyAdminClient returns the base url and an http client for caddy's admin
+// endpoint, which is either unix:/path/to/socket or a tcp address (host:port or an http
+// url)
+func makeCaddyAdminClient(address string) (baseURL string, client *http.Client, err error) {
+	// caddy's own syntax (unix//path) is also accepted
+	if strings.HasPrefix(address, "unix:") || strings.HasPrefix(address, "unix/") {
+		socketPath := "/" + strings.TrimLeft(address[len("unix:"):], "/")
+		if socketPath == "/" {
+			return "", nil, errors.New("socket path is blank")
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := &net.Dialer{}
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+
+		// caddy checks the host of requests to its admin endpoint, a socket has no host
+		return "http://127.0.0.1", &http.Client{Timeout: 30 * time.Second, Transport: transport}, nil
+	}
+
+	baseURL = strings.TrimSuffix(address, "/")
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+
+	return baseURL, &http.Client{Timeout: 30 * time.Second}, nil
+}
+
+// name implements postWriteAction
+func (ca *caddyAction) name() string {
+	if ca.configFile != "" {
+		return fmt.Sprintf("caddy admin api load (%s)", ca.configFile)
+	}
+	return "caddy admin api load_pem"
+}
+
+// do sends a request to the caddy admin api. If caddy responds with an error, the error
+// it reported is returned.
+func (ca *caddyAction) do(ctx context.Context, method, path string, header http.Header, reqBody []byte) (respHeader http.Header, respBody []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, ca.baseURL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := ca.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// caddy errors are json {"error": "..."}
+		var caddyErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &caddyErr) == nil && caddyErr.Error != "" {
+			return nil, nil, fmt.Errorf("caddy error: %s (status: %d)", caddyErr.Error, resp.StatusCode)
+		}
+		return nil, nil, fmt.Errorf("caddy error (status: %d, %s)", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	return resp.Header, respBody, nil
+}
+
+// caddyJsonObject decodes the object at key of parent (an empty object if it doesn't
+// exist)
+func caddyJsonObject(parent map[string]json.RawMessage, key string) (map[string]json.RawMessage, error) {
+	obj := map[string]json.RawMessage{}
+	if raw, ok := parent[key]; ok && string(raw) != "null" {
+		err := json.Unmarshal(raw, &obj)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an object (%s)", key, err)
+		}
+	}
+
+	return obj, nil
+}
+
+// setLoadPem replaces the tagged entry of a tls.certificates object with the key/cert
+// (other certificate loaders and load_pem entries are kept)
+func (ca *caddyAction) setLoadPem(certificates map[string]json.RawMessage, entry caddyLoadPem) error {
+	loadPems := []json.RawMessage{}
+	if raw, ok := certificates["load_pem"]; ok && string(raw) != "null" {
+		err := json.Unmarshal(raw, &loadPems)
+		if err != nil {
+			return fmt.Errorf("failed to decode load_pem (%s)", err)
+		}
+	}
+
+	// drop the client's previous entry
+	loadPems = slices.DeleteFunc(loadPems, func(raw json.RawMessage) bool {
+		var existing caddyLoadPem
+		return json.Unmarshal(raw, &existing) == nil && slices.Contains(existing.Tags, ca.tag)
+	})
+
+	newEntry, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	certificates["load_pem"], err = json.Marshal(append(loadPems, newEntry))
+	return err
+}
+
+// run implements postWriteAction
+func (ca *caddyAction) run(ctx context.Context, keyPem, certPem []byte) error {
+	keyPem, err := convertKeyPem(keyPem, ca.keyFormat)
+	if err != nil {
+		return err
+	}
+
+	entry := caddyLoadPem{
+		Certificate: string(certPem),
+		Key:         string(keyPem),
+		Tags:        []string{ca.tag},
+	}
+
+	if ca.configFile != "" {
+		return ca.load(ctx, entry)
+	}
+
+	return ca.patch(ctx, entry)
+}
+
+// patch changes the certificates of the tls app in caddy's running config
+func (ca *caddyAction) patch(ctx context.Context, entry caddyLoadPem) error {
+	// get apps (caddy can't get a path whose parent doesn't exist, and the tls app may not)
+	header, body, err := ca.do(ctx, http.MethodGet, "/config/apps", nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get caddy apps config (%s)", err)
+	}
+
+	apps := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &apps)
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy apps config (%s)", err)
+	}
+	if apps == nil {
+		return errors.New("caddy has no apps configured")
+	}
+
+	// tls app doesn't exist yet so it is created instead
+	method := http.MethodPatch
+	if raw, ok := apps["tls"]; !ok || string(raw) == "null" {
+		method = http.MethodPut
+	}
+
+	tlsApp, err := caddyJsonObject(apps, "tls")
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy apps config (%s)", err)
+	}
+	certificates, err := caddyJsonObject(tlsApp, "certificates")
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy apps config (%s)", err)
+	}
+
+	err = ca.setLoadPem(certificates, entry)
+	if err != nil {
+		return err
+	}
+
+	tlsApp["certificates"], err = json.Marshal(certificates)
+	if err != nil {
+		return err
+	}
+	newBody, err := json.Marshal(tlsApp)
+	if err != nil {
+		return err
+	}
+
+	// don't overwrite a config change made since the get
+	reqHeader := http.Header{}
+	if etag := header.Get("Etag"); etag != "" {
+		reqHeader.Set("If-Match", etag)
+	}
+
+	_, _, err = ca.do(ctx, method, caddyTLSAppPath, reqHeader, newBody)
+	if err != nil {
+		return fmt.Errorf("failed to update caddy tls app config (%s)", err)
+	}
+
+	return nil
+}
+
+// load loads the config file, with the key/cert added, as caddy's whole config
+func (ca *caddyAction) load(ctx context.Context, entry caddyLoadPem) error {
+	configJson, err := os.ReadFile(ca.configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read caddy config file (%s)", err)
+	}
+
+	// only the path to the certificates is decoded, the rest is kept as is
+	config := map[string]json.RawMessage{}
+	err = json.Unmarshal(configJson, &config)
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy config file (%s)", err)
+	}
+
+	apps, err := caddyJsonObject(config, "apps")
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy config file (%s)", err)
+	}
+	tlsApp, err := caddyJsonObject(apps, "tls")
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy config file (%s)", err)
+	}
+	certificates, err := caddyJsonObject(tlsApp, "certificates")
+	if err != nil {
+		return fmt.Errorf("failed to decode caddy config file (%s)", err)
+	}
+
+	err = ca.setLoadPem(certificates, entry)
+	if err != nil {
+		return err
+	}
+
+	tlsApp["certificates"], err = json.Marshal(certificates)
+	if err != nil {
+		return err
+	}
+	apps["tls"], err = json.Marshal(tlsApp)
+	if err != nil {
+		return err
+	}
+	config["apps"], err = json.Marshal(apps)
+	if err != nil {
+		return err
+	}
+
+	newConfig, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = ca.do(ctx, http.MethodPost, "/load", nil, newConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load caddy config (%s)", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// runCommand runs a one-off command specified on the command line instead of
+// running the client
+func (app *app) runCommand(args []string) error {
+	switch args[0] {
+	case "rollback":
+		return app.commandRollback(args[1:])
+
+	default:
+		// fallthrough
+	}
+
+	return fmt.Errorf("unknown command \"%s\" (valid commands: rollback)", args[0])
+}
+
+// commandRollback lists the archived versions (if no version arg) or rolls back the
+// files to the specified archive version
+func (app *app) commandRollback(args []string) error {
+	// no version, list versions
+	if len(args) == 0 {
+		versions, err := app.archiveVersions()
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return errors.New("no archived versions exist (is CW_CLIENT_ARCHIVE_COUNT set?)")
+		}
+
+		for _, v := range versions {
+			app.logger.Infof("archive version %d: serial %s, expires %s, installed %s, sha256 %s", v.Version, v.Serial, v.NotAfter, v.InstalledAt, v.FingerprintSHA256)
+		}
+		app.logger.Info("to rollback, run the rollback command again with the desired version number")
+
+		return nil
+	}
+
+	version, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid version \"%s\"", args[0])
+	}
+
+	return app.rollbackToArchiveVersion(version)
+}
@@ -8,9 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,6 +21,9 @@ import (
 	"time"
 
 	dockerClient "github.com/docker/docker/client"
+	envoyCache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	envoyResource "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/youmark/pkcs8"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -41,8 +47,30 @@ import (
 //    CW_CLIENT_RESTART_DOCKER_CONTAINER0 - name of a container to restart via docker sock on key/cert file update (useful for containers that need to restart to update certs)
 //    CW_CLIENT_RESTART_DOCKER_CONTAINER1 - another container name that should be restarted (keep adding 1 to the number for more)
 //		CW_CLIENT_RESTART_DOCKER_CONTAINER2 ... etc.
+//    CW_CLIENT_RESTART_DOCKER_CONTAINER0_ACTION	- how to update the container: restart, stop, signal, or exec (default is restart, or stop if
+//																					CW_CLIENT_RESTART_DOCKER_STOP_ONLY)
+//    CW_CLIENT_RESTART_DOCKER_CONTAINER0_SIGNAL	- signal to send to the container for the signal action (e.g. SIGHUP)
+//    CW_CLIENT_RESTART_DOCKER_CONTAINER0_EXEC		- command to run in the container for the exec action, split on spaces (e.g. nginx -s reload);
+//																					it fails if the command doesn't exit 0
+//    CW_CLIENT_RESTART_DOCKER_CONTAINER0_ORDER		- order group of the container, lower groups are updated first (default 0); containers in the
+//																					same group are updated at the same time
+//    CW_CLIENT_RESTART_DOCKER_LABEL0	- a label filter (e.g. certwarden.restart=true or certwarden.cert) that selects running containers to
+//																update, looked up each time containers are updated (useful with compose or scaled replicas)
+//		CW_CLIENT_RESTART_DOCKER_LABEL1 ... etc. (containers matching any of the filters are updated)
+//		Note: Containers selected by label can set their own options using the labels certwarden.action, certwarden.signal,
+//		certwarden.exec, and certwarden.order (same values as the _ACTION, _SIGNAL, _EXEC, and _ORDER vars above)
 //		Note: Restart is based on file update, so use the vars above to set a file update time window and day(s) of week
 //		CW_CLIENT_RESTART_DOCKER_STOP_ONLY	- if 'true' docker containers will be stopped instead of restarted (this is useful if another process like systemctl will start them back up)
+//		CW_CLIENT_RESTART_DOCKER_MAX_CONCURRENT	- max number of containers in an order group that are updated at the same time (default 0 is no limit)
+//		CW_CLIENT_RESTART_DOCKER_WAIT_HEALTHY		- if 'true', wait for each updated container to be healthy before updating more; if a container
+//																			doesn't become healthy, the remaining containers are not updated (a container that fails to update,
+//																			e.g. it doesn't exist, is logged and the rest are still updated)
+//		CW_CLIENT_RESTART_DOCKER_HEALTH_TIMEOUT	- seconds to wait for a container to be healthy
+//		CW_CLIENT_RESTART_DOCKER_RUNNING_SECONDS	- a container without a health check is healthy once it has been running for this many seconds
+//		CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK	- if 'true', when containers don't become healthy after new files are written, the previous files
+//																			are reinstated, containers are updated again, and the new cert is not written again until a newer
+//																			cert is received (enables CW_CLIENT_RESTART_DOCKER_WAIT_HEALTHY and keeps at least 2 archive versions);
+//																			only files are rolled back, so it can't be used with output backends other than CW_CLIENT_DOCKER_COPY_PATH
 
 //		CW_CLIENT_LOGLEVEL									- zap log level for the app
 //		CW_CLIENT_BIND_ADDRESS							- address to bind the https server to
@@ -51,6 +79,16 @@ import (
 // 		CW_CLIENT_CERT_PATH				- the path to save all keys and certificates to
 //    CW_CLIENT_KEY_PERM				- permissions for files containing the key
 //    CW_CLIENT_CERT_PERM				- permissions for files only containing the cert
+//    CW_CLIENT_KEY_FORMAT			- format to write key.pem in: pkcs1 (RSA only), sec1 (ECDSA only), or pkcs8 (blank is the format sent by the server)
+//    CW_CLIENT_FILE_VERSIONED_SET	- if `true`, files are written into a new versioned directory and all of them are swapped in at
+//																	once via a symlink (so a reader never sees a new key with an old cert); each file in CW_CLIENT_CERT_PATH
+//																	becomes a symlink
+
+//    CW_CLIENT_ARCHIVE_COUNT		- number of versions of the written files to keep in CW_CLIENT_CERT_PATH/archive (0 disables the archive);
+//																	an archived version can be reinstated with the command `certwarden-client rollback <version>`
+//																	(run without a version to list the archived versions); a rollback reinstates the files (including
+//																	those copied into docker containers) but not other output backends; output files that weren't
+//																	archived with the version (e.g. enabled since) are removed
 
 //    CW_CLIENT_PFX_CREATE			- if `true`, an additional pkcs12 encoded key/certchain will be generated with modern algorithms
 //    CW_CLIENT_PFX_FILENAME		- if pfx create enabled, the filename for the pfx generated
@@ -61,6 +99,174 @@ import (
 //    CW_CLIENT_PFX_LEGACY_FILENAME		- if pfx create enabled, the filename for the legacy pfx generated
 //    CW_CLIENT_PFX_LEGACY_PASSWORD		- if pfx create enabled, the password for the legacy pfx file generated
 
+//		Any number of additional pkcs12 outputs, each with its own settings, can also be configured:
+//    CW_CLIENT_PFX0_FILENAME				- filename of a pkcs12 output
+//    CW_CLIENT_PFX0_PASSWORD				- password for the pkcs12 output
+//    CW_CLIENT_PFX0_ENCODING				- encoding profile: modern (default), modern2026, legacy-des, legacy-rc2, or passwordless
+//    CW_CLIENT_PFX0_FRIENDLY_NAME	- friendly name (alias) of the certs in a ca only output (e.g. for a Java keystore), numbered if
+//																there is more than one cert (default is each cert's subject); only supported with ca only
+//    CW_CLIENT_PFX0_CA_ONLY				- if `true`, the output is a trust store that only contains the chain (no key or leaf cert)
+//    CW_CLIENT_PFX1_FILENAME				- another pkcs12 output (keep adding 1 to the number for more)
+//		CW_CLIENT_PFX1_PASSWORD ... etc.
+
+//    CW_CLIENT_CA_BUNDLE_CREATE						- if `true`, an additional pem bundle of the issuing CA chain will be generated (e.g. for clients to trust)
+//    CW_CLIENT_CA_BUNDLE_FILENAME					- if ca bundle create enabled, the filename for the ca bundle generated
+//    CW_CLIENT_CA_BUNDLE_ROOT_FILE				- pem file of root cert(s) to append to the ca bundle
+//    CW_CLIENT_CA_BUNDLE_ROOT_FROM_SYSTEM	- if `true`, the root the chain verifies to in the system cert pool is appended to the ca bundle
+
+//    CW_CLIENT_TRAEFIK_CONFIG_CREATE				- if `true`, a traefik dynamic config that loads key.pem and certchain.pem is generated (point
+//																					traefik's file provider at it so new certs are loaded without restarting traefik); the traefik
+//																					config is written like any other output file, so every container in CW_CLIENT_RESTART_DOCKER_CONTAINER
+//																					and CW_CLIENT_RESTART_DOCKER_LABEL is still updated when it changes: remove traefik from those
+//																					(and make sure it doesn't match a label filter) or it is restarted anyway
+//    CW_CLIENT_TRAEFIK_CONFIG_FILENAME			- if traefik config create enabled, the filename for the traefik config generated
+//    CW_CLIENT_TRAEFIK_CONFIG_FORMAT				- yaml or toml (default is based on the filename's extension)
+//    CW_CLIENT_TRAEFIK_CONFIG_CERT_PATH		- path of CW_CLIENT_CERT_PATH as seen by traefik (e.g. where it is mounted in the traefik container)
+//    CW_CLIENT_TRAEFIK_CONFIG_DEFAULT_CERT	- if `true`, the cert is also set as the default cert of traefik's default tls store
+
+//    CW_CLIENT_COMBINED_PEM_CREATE		- if `true`, an additional pem of the cert chain followed by the key is generated (e.g. for haproxy,
+//																		a cert hot loaded over haproxy's runtime api is only in memory, so haproxy's crt file must
+//																		also be updated for the new cert to survive a haproxy restart or reload)
+//    CW_CLIENT_COMBINED_PEM_FILENAME	- if combined pem create enabled, the filename for the combined pem generated
+
+//    CW_CLIENT_KEY_OUTPUT0_FILENAME	- filename of an additional key output (e.g. to have the key in more than one format)
+//    CW_CLIENT_KEY_OUTPUT0_FORMAT		- format of the additional key output: pkcs1 (RSA only), sec1 (ECDSA only), or pkcs8
+//    CW_CLIENT_KEY_OUTPUT1_FILENAME	- another additional key output (keep adding 1 to the number for more)
+//		CW_CLIENT_KEY_OUTPUT1_FORMAT ... etc.
+
+//    CW_CLIENT_KEY_ENCRYPTED_CREATE					- if `true`, an additional password encrypted PKCS#8 pem of the key will be generated
+//    CW_CLIENT_KEY_ENCRYPTED_FILENAME				- if encrypted key create enabled, the filename for the encrypted key generated
+//    CW_CLIENT_KEY_ENCRYPTED_PASSWORD				- if encrypted key create enabled, the password used to encrypt the key
+//    CW_CLIENT_KEY_ENCRYPTED_PASSWORD_FILE	- alternative to CW_CLIENT_KEY_ENCRYPTED_PASSWORD, a file (e.g. a docker secret) containing the password
+//    CW_CLIENT_KEY_ENCRYPTED_CIPHER					- cipher used to encrypt the key (aes-128-cbc, aes-192-cbc, aes-256-cbc, aes-128-gcm, aes-192-gcm,
+//																							aes-256-gcm, or des-ede3-cbc)
+//    CW_CLIENT_KEY_ENCRYPTED_KDF						- kdf used to derive the encryption key from the password (pbkdf2-sha256, pbkdf2-sha1, or scrypt)
+
+//    CW_CLIENT_OCSP_STAPLE_CREATE							- if `true`, a DER ocsp response for the cert on disk is written and kept refreshed (e.g. for
+//																					nginx ssl_stapling_file or haproxy .ocsp)
+//    CW_CLIENT_OCSP_STAPLE_FILENAME						- if ocsp staple create enabled, the filename for the ocsp response
+//    CW_CLIENT_OCSP_RESPONDER_URL							- ocsp responder to use instead of the one in the cert's AIA (e.g. a local stand-in)
+//    CW_CLIENT_OCSP_STAPLE_RESTART_CONTAINERS	- if `true`, docker containers are restarted when the ocsp staple file is updated
+//		Note: The ocsp staple file is written whenever it is refreshed (regardless of the file update window)
+//    CW_CLIENT_OCSP_HTTPS_STAPLING						- if `true`, the client's own https server staples a current ocsp response to its cert
+
+//    CW_CLIENT_SDS_LISTEN									- if set, an envoy secret discovery service (sds) grpc server that serves the client's key/cert
+//																				is started on this address (unix:/path or [host]:port)
+//    CW_CLIENT_SDS_CERT_SECRET_NAME				- name of the sds tls_certificate secret (the key and cert chain)
+//    CW_CLIENT_SDS_VALIDATION_SECRET_NAME	- name of the sds validation_context secret (the cert's issuing CA chain)
+//		Note: The sds server has no authentication, only bind it to a unix socket or a trusted network
+
+//    CW_CLIENT_KUBE_SECRET_NAME				- if set, the key/cert are also written to this kubernetes.io/tls Secret (tls.crt and tls.key)
+//    CW_CLIENT_KUBE_SECRET_NAMESPACE		- namespace of the Secret (default is the service account's or kubeconfig context's namespace)
+//    CW_CLIENT_KUBE_SECRET_INCLUDE_CA	- if `true`, the issuing CA chain is also written to the Secret as ca.crt (if `false`, an existing ca.crt is removed)
+//    CW_CLIENT_KUBECONFIG							- kubeconfig file to use for the kubernetes api (default is the in-cluster service account)
+//		Note: Output backends (such as the Secret) follow the same file update window as files
+
+//    CW_CLIENT_VAULT_ADDRESS						- if set, the key/cert are also written to a vault kv v2 secret at this vault (e.g. https://vault:8200)
+//    CW_CLIENT_VAULT_NAMESPACE					- vault enterprise namespace
+//    CW_CLIENT_VAULT_CA_FILE						- pem file of the ca(s) to trust for the vault server (default is the system's)
+//    CW_CLIENT_VAULT_KV_MOUNT					- mount path of the kv v2 secrets engine
+//    CW_CLIENT_VAULT_KV_PATH						- path of the secret within the kv mount (fields: key, certchain, pfx, and certwarden_source,
+//																			a hash used to detect changes)
+//    CW_CLIENT_VAULT_TOKEN							- token to authenticate with
+//    CW_CLIENT_VAULT_APPROLE_ROLE_ID		- alternative to a token, approle role id to login with
+//    CW_CLIENT_VAULT_APPROLE_SECRET_ID	- approle secret id to login with
+//    CW_CLIENT_VAULT_APPROLE_MOUNT			- mount path of the approle auth method
+//    CW_CLIENT_VAULT_PFX_CREATE				- if `true`, a base64 encoded pkcs12 of the key/cert is also written to the secret
+//    CW_CLIENT_VAULT_PFX_PASSWORD			- password for the pkcs12
+//		Note: Any vault var that is a secret can instead be read from a file by adding _FILE to the name (e.g. CW_CLIENT_VAULT_TOKEN_FILE)
+
+//    CW_CLIENT_S3_ENDPOINT						- if set, outputs are also uploaded to this s3-compatible endpoint (e.g. https://minio:9000)
+//    CW_CLIENT_S3_REGION							- region used to sign requests
+//    CW_CLIENT_S3_BUCKET							- bucket to upload to (path-style requests are used)
+//    CW_CLIENT_S3_PREFIX							- prefix prepended to each object key (e.g. certs/example.com/)
+//    CW_CLIENT_S3_ACCESS_KEY_ID			- access key id
+//    CW_CLIENT_S3_SECRET_ACCESS_KEY	- secret access key (or use CW_CLIENT_S3_SECRET_ACCESS_KEY_FILE)
+//    CW_CLIENT_S3_CA_FILE						- pem file of the ca(s) to trust for the endpoint (default is the system's)
+//    CW_CLIENT_S3_FILES							- outputs to upload, separated by spaces (key.pem, certchain.pem, and/or the filename of any
+//																		configured additional output)
+//    CW_CLIENT_S3_SSE								- server side encryption: AES256 or aws:kms (blank is none)
+//    CW_CLIENT_S3_SSE_KMS_KEY_ID			- kms key id when CW_CLIENT_S3_SSE is aws:kms
+
+//    CW_CLIENT_SFTP0_HOST							- host[:port] of a remote host that outputs are copied to over sftp (e.g. an appliance)
+//    CW_CLIENT_SFTP0_USER							- ssh user
+//    CW_CLIENT_SFTP0_KEY_FILE					- ssh private key file used to authenticate
+//    CW_CLIENT_SFTP0_KEY_PASSPHRASE		- passphrase of the private key (or use CW_CLIENT_SFTP0_KEY_PASSPHRASE_FILE)
+//    CW_CLIENT_SFTP0_KNOWN_HOSTS				- known_hosts file used to verify the host (default is CW_CLIENT_SFTP_KNOWN_HOSTS)
+//    CW_CLIENT_SFTP0_REMOTE_DIR				- remote dir to copy the outputs to
+//    CW_CLIENT_SFTP0_FILES							- outputs to copy, separated by spaces (key.pem, certchain.pem, and/or the filename of any
+//																			configured additional output)
+//    CW_CLIENT_SFTP0_RELOAD_COMMAND		- command to run on the host after copying (e.g. to reload a service)
+//    CW_CLIENT_SFTP1_HOST							- another remote host (keep adding 1 to the number for more)
+//		CW_CLIENT_SFTP1_USER ... etc.
+//    CW_CLIENT_SFTP_KNOWN_HOSTS				- default known_hosts file for all sftp hosts
+
+//    CW_CLIENT_DOCKER_COPY_PATH		- if set, outputs are copied to this dir inside each of the docker containers that are updated
+//																(CW_CLIENT_RESTART_DOCKER_CONTAINER and CW_CLIENT_RESTART_DOCKER_LABEL), for containers that can't
+//																share a volume with the client; containers are then updated with their configured action
+//    CW_CLIENT_DOCKER_COPY_FILES		- outputs to copy, separated by spaces (key.pem, certchain.pem, and/or the filename of any
+//																configured additional output)
+//    CW_CLIENT_DOCKER_COPY_OWNER		- numeric uid:gid that owns the copied files in the containers (file modes are the output's permissions)
+
+//    CW_CLIENT_DOCKER_SWARM_SECRET0_NAME		- name of a swarm secret to rotate (at most 14 characters); each cert is stored in a new secret
+//																				named <name>-<cert serial in hex>-<hash of the key/cert and output config> and old versions are
+//																				removed (the previous version is kept)
+//    CW_CLIENT_DOCKER_SWARM_SECRET0_FILE		- output stored in the secret (key.pem, certchain.pem, or the filename of any configured additional output)
+//    CW_CLIENT_DOCKER_SWARM_SECRET0_CONFIG	- if 'true', a swarm config is used instead of a secret (e.g. for a cert)
+//		CW_CLIENT_DOCKER_SWARM_SECRET1_NAME ... etc.
+//    CW_CLIENT_DOCKER_SWARM_SERVICE0				- swarm service that is updated to use the new secrets; the service must already use each secret
+//																				(either <name> or a version created by the client), the target (path, owner, and mode) is kept
+//		CW_CLIENT_DOCKER_SWARM_SERVICE1 ... etc.
+
+//    CW_CLIENT_CONSUL_ADDRESS		- if set, outputs are also written to consul kv at this address (e.g. http://consul:8500)
+//    CW_CLIENT_CONSUL_TOKEN			- acl token (or use CW_CLIENT_CONSUL_TOKEN_FILE)
+//    CW_CLIENT_CONSUL_CA_FILE		- pem file of the ca(s) to trust for consul (default is the system's)
+//    CW_CLIENT_CONSUL_PREFIX			- prefix of the keys written, each output's key is the prefix + its filename (e.g. certs/example.com/)
+//    CW_CLIENT_CONSUL_FILES			- outputs to write, separated by spaces (key.pem, certchain.pem, and/or the filename of any
+//																configured additional output)
+
+//    CW_CLIENT_ETCD_ADDRESS			- if set, outputs are also written to etcd at this address (e.g. http://etcd:2379)
+//    CW_CLIENT_ETCD_USERNAME			- etcd user (blank if etcd auth is disabled)
+//    CW_CLIENT_ETCD_PASSWORD			- etcd password (or use CW_CLIENT_ETCD_PASSWORD_FILE)
+//    CW_CLIENT_ETCD_CA_FILE			- pem file of the ca(s) to trust for etcd (default is the system's)
+//    CW_CLIENT_ETCD_PREFIX				- prefix of the keys written, each output's key is the prefix + its filename
+//    CW_CLIENT_ETCD_FILES				- outputs to write, separated by spaces
+//		Note: All of the keys (for consul or etcd) are written together in one transaction
+
+//    CW_CLIENT_HAPROXY0_SOCKET			- haproxy runtime api socket that new certs are hot loaded into after files are written (unix:/path or
+//																tcp:host:port)
+//    CW_CLIENT_HAPROXY0_CERT_PATH	- path of the cert as it is loaded in haproxy's config (i.e. its crt, such as the combined pem)
+//    CW_CLIENT_HAPROXY0_FALLBACK_CONTAINER	- name of haproxy's docker container, it is restarted if the hot load fails (optional)
+//    CW_CLIENT_HAPROXY1_SOCKET			- another haproxy (keep adding 1 to the number for more)
+//		CW_CLIENT_HAPROXY1_CERT_PATH ... etc.
+//		Note: A hot loaded haproxy should not also be in the docker containers to update, those are always updated when files change
+//    CW_CLIENT_SIGNAL0_PID_FILE				- pid file of a process to signal after files are written (e.g. /run/nginx.pid)
+//    CW_CLIENT_SIGNAL0_PROCESS_NAME		- alternative to a pid file, name of the process(es) to signal (found using /proc; if a matching
+//																			process's parent also matches, such as nginx workers, only the parent is signaled)
+//    CW_CLIENT_SIGNAL0_SIGNAL					- signal to send (e.g. SIGHUP or USR1)
+//    CW_CLIENT_SIGNAL1_PID_FILE				- another process (keep adding 1 to the number for more)
+//		CW_CLIENT_SIGNAL1_SIGNAL ... etc.
+//		Note: A process signal is a post write action, the process must be visible to the client (e.g. same pid namespace)
+
+//    CW_CLIENT_PRE_WRITE_HOOK0_COMMAND		- command (run with /bin/sh -c) to run before new files are written; if it fails, nothing is written
+//    CW_CLIENT_PRE_WRITE_HOOK0_TIMEOUT		- seconds the command may run before it is killed (and considered failed)
+//    CW_CLIENT_PRE_WRITE_HOOK0_DIR				- working directory of the command
+//    CW_CLIENT_PRE_WRITE_HOOK0_USER			- user (name, uid, or uid:gid) to run the command as (default is the client's user)
+//    CW_CLIENT_PRE_WRITE_HOOK0_ENV0			- additional environment variable (KEY=VALUE) for the command (keep adding 1 to the number for more)
+//    CW_CLIENT_PRE_WRITE_HOOK1_COMMAND		- another pre-write hook (keep adding 1 to the number for more)
+//		CW_CLIENT_PRE_WRITE_HOOK1_TIMEOUT ... etc.
+//    CW_CLIENT_POST_WRITE_HOOK0_COMMAND	- command (run with /bin/sh -c) to run after new files are written (e.g. systemctl reload nginx)
+//		CW_CLIENT_POST_WRITE_HOOK0_TIMEOUT ... etc. (same options as pre-write hooks)
+//		Note: Hooks receive CW_HOOK_STAGE, CW_HOOK_CERT_PATH, CW_HOOK_CHANGED_FILES (space separated paths), CW_HOOK_CERT_FINGERPRINT,
+//		CW_HOOK_CERT_SERIAL, and CW_HOOK_CERT_NOT_AFTER in their environment
+
+//    CW_CLIENT_CADDY_ADMIN_ADDRESS	- caddy admin endpoint that new certs are loaded into after files are written (unix:/path or host:port)
+//    CW_CLIENT_CADDY_CONFIG_FILE		- caddy json config that is loaded (with the cert added) using /load; if not set, only the tls app's
+//																certificates are changed in caddy's running config
+//    CW_CLIENT_CADDY_TAG						- tag of the tls.certificates.load_pem entry that the client manages
+//		Note: Post write actions (haproxy, process signals, and caddy) don't change which docker containers are updated, the
+//		configured containers are always updated when files change
+
 // defaults for Optional vars
 const (
 	defaultUpdateTimeStartHour   = 3
@@ -69,15 +275,23 @@ const (
 	defaultUpdateTimeEndMinute   = 0
 	defaultUpdateDayOfWeek       = ""
 
-	defaultRestartDockerStopOnly = false
+	defaultRestartDockerStopOnly      = false
+	defaultDockerSignal               = "SIGHUP"
+	defaultDockerMaxConcurrent        = 0
+	defaultDockerWaitHealthy          = false
+	defaultDockerHealthTimeoutSeconds = 120
+	defaultDockerRunningSeconds       = 10
+	defaultDockerAutoRollback         = false
 
 	defaultLogLevel    = zapcore.InfoLevel
 	defaultBindAddress = ""
 	defaultBindPort    = 5055
 
-	defaultCertStoragePath = "/opt/certwarden/certs"
-	defaultKeyPermissions  = fs.FileMode(0600)
-	defaultCertPermissions = fs.FileMode(0644)
+	defaultCertStoragePath  = "/opt/certwarden/certs"
+	defaultKeyPermissions   = fs.FileMode(0600)
+	defaultCertPermissions  = fs.FileMode(0644)
+	defaultFileVersionedSet = false
+	defaultArchiveCount     = 0
 
 	defaultPFXCreate   = false
 	defaultPFXFilename = "key_certchain.pfx"
@@ -86,6 +300,57 @@ const (
 	defaultPFXLegacyCreate   = false
 	defaultPFXLegacyFilename = "key_certchain.legacy.pfx"
 	defaultPFXLegacyPassword = ""
+
+	defaultPFXOutputEncoding = "modern"
+
+	defaultCABundleCreate         = false
+	defaultCABundleFilename       = "ca_bundle.pem"
+	defaultCABundleRootFromSystem = false
+
+	defaultTraefikConfigCreate      = false
+	defaultTraefikConfigFilename    = "traefik_tls.yml"
+	defaultTraefikConfigDefaultCert = false
+
+	defaultCombinedPemCreate   = false
+	defaultCombinedPemFilename = "certchain_key.pem"
+
+	defaultKeyEncryptedCreate   = false
+	defaultKeyEncryptedFilename = "key.encrypted.pem"
+	defaultKeyEncryptedCipher   = "aes-256-cbc"
+	defaultKeyEncryptedKDF      = "pbkdf2-sha256"
+
+	defaultOCSPStapleCreate            = false
+	defaultOCSPStapleFilename          = "certchain.pem.ocsp"
+	defaultOCSPStapleRestartContainers = false
+	defaultOCSPHttpsStapling           = false
+
+	defaultSDSCertSecretName       = "certwarden-cert"
+	defaultSDSValidationSecretName = "certwarden-ca"
+
+	defaultKubeSecretIncludeCA = false
+
+	defaultVaultKVMount      = "secret"
+	defaultVaultAppRoleMount = "approle"
+	defaultVaultPfxCreate    = false
+
+	defaultS3Region = "us-east-1"
+	defaultS3Files  = "key.pem certchain.pem"
+
+	defaultSftpPort       = "22"
+	defaultSftpKnownHosts = "/root/.ssh/known_hosts"
+	defaultSftpFiles      = "key.pem certchain.pem"
+
+	defaultDockerCopyFiles = "key.pem certchain.pem"
+	defaultDockerCopyOwner = "0:0"
+
+	defaultCaddyTag = "certwarden-client"
+
+	defaultProcessSignal = "SIGHUP"
+
+	defaultExecHookTimeoutSeconds = 60
+
+	defaultConsulFiles = "key.pem certchain.pem"
+	defaultEtcdFiles   = "key.pem certchain.pem"
 )
 
 //
@@ -102,6 +367,17 @@ type app struct {
 
 	pendingJobCancel context.CancelFunc
 
+	ocspStapleFileRefresh  chan struct{}
+	httpsOCSPStapleRefresh chan struct{}
+
+	sdsCache *envoyCache.LinearCache
+
+	preWriteHooks  []execHook
+	postWriteHooks []execHook
+
+	outputBackends   []outputBackend
+	postWriteActions []postWriteAction
+
 	httpClient      *http.Client
 	dockerAPIClient *dockerClient.Client
 	tlsCert         *SafeCert
@@ -119,21 +395,85 @@ type config struct {
 	FileUpdateTimeEndMinute        int
 	FileUpdateTimeIncludesMidnight bool
 	FileUpdateDaysOfWeek           map[time.Weekday]struct{}
-	DockerContainersToRestart      []string
+	DockerContainersToRestart      []dockerContainer
+	DockerLabelFilters             []string
 	DockerStopOnly                 bool
+	DockerMaxConcurrent            int
+	DockerWaitHealthy              bool
+	DockerHealthTimeoutSeconds     int
+	DockerRunningSeconds           int
+	DockerAutoRollback             bool
+	DockerCopyPath                 string
+	DockerSwarmServices            []string
 	KeyName                        string
 	KeyApiKey                      string
 	CertName                       string
 	CertApiKey                     string
 	CertStoragePath                string
 	KeyPermissions                 fs.FileMode
+	KeyFormat                      string
+	KeyOutputs                     []keyOutputConfig
 	CertPermissions                fs.FileMode
+	FileVersionedSet               bool
+	ArchiveCount                   int
 	PfxCreate                      bool
 	PfxFilename                    string
 	PfxPassword                    string
 	PfxLegacyCreate                bool
 	PfxLegacyFilename              string
 	PfxLegacyPassword              string
+	PfxOutputs                     []pfxOutputConfig
+	CABundleCreate                 bool
+	CABundleFilename               string
+	CABundleRootPem                []byte
+	CABundleRootFromSystem         bool
+	TraefikConfigCreate            bool
+	TraefikConfigFilename          string
+	TraefikConfigOptions           traefikConfigOptions
+	CombinedPemCreate              bool
+	CombinedPemFilename            string
+	KeyEncryptedCreate             bool
+	KeyEncryptedFilename           string
+	KeyEncryptedPassword           string
+	KeyEncryptedOpts               *pkcs8.Opts
+	OCSPStapleCreate               bool
+	OCSPStapleFilename             string
+	OCSPResponderURL               string
+	OCSPStapleRestartContainers    bool
+	OCSPHttpsStapling              bool
+	SDSListen                      string
+	SDSCertSecretName              string
+	SDSValidationSecretName        string
+	KubeSecretName                 string
+	KubeSecretNamespace            string
+	KubeSecretIncludeCA            bool
+	KubeConfigPath                 string
+	VaultAddress                   string
+	VaultKVMount                   string
+	VaultKVPath                    string
+	VaultPfxCreate                 bool
+	S3Endpoint                     string
+	S3Bucket                       string
+	S3Prefix                       string
+	S3Files                        []string
+	ConsulAddress                  string
+	ConsulPrefix                   string
+	ConsulFiles                    []string
+	EtcdAddress                    string
+	EtcdPrefix                     string
+	EtcdFiles                      []string
+}
+
+// keyOutputConfig is the config for an additional key output
+type keyOutputConfig struct {
+	Filename string
+	Format   string
+}
+
+// pfxOutputConfig is the config for an additional pkcs12 output
+type pfxOutputConfig struct {
+	Filename string
+	Options  pfxOptions
 }
 
 // configureApp creates the application from environment variables and/or defaults;
@@ -260,29 +600,45 @@ func configureApp() (*app, error) {
 		app.cfg.FileUpdateTimeStartMinute, app.cfg.FileUpdateTimeEndHour, app.cfg.FileUpdateTimeEndMinute)
 
 	// CW_CLIENT_RESTART_DOCKER_CONTAINER (0... etc.)
-	app.cfg.DockerContainersToRestart = []string{}
+	app.cfg.DockerContainersToRestart = []dockerContainer{}
 	for i := 0; true; i++ {
-		containerName := os.Getenv("CW_CLIENT_RESTART_DOCKER_CONTAINER" + strconv.Itoa(i))
-		if containerName == "" {
+		prefix := "CW_CLIENT_RESTART_DOCKER_CONTAINER" + strconv.Itoa(i)
+
+		name := os.Getenv(prefix)
+		if name == "" {
 			// if next number not specified, done
 			break
 		}
-		app.cfg.DockerContainersToRestart = append(app.cfg.DockerContainersToRestart, containerName)
-	}
-	if len(app.cfg.DockerContainersToRestart) > 0 {
-		app.dockerAPIClient, err = dockerClient.NewClientWithOpts(
-			dockerClient.FromEnv,
-			dockerClient.WithAPIVersionNegotiation(),
-		)
+
+		signalStr := os.Getenv(prefix + "_SIGNAL")
+		if signalStr == "" && strings.ToLower(os.Getenv(prefix+"_ACTION")) == dockerActionSignal {
+			app.logger.Debugf("%s_SIGNAL not specified, using default \"%s\"", prefix, defaultDockerSignal)
+		}
+
+		container, err := makeDockerContainer(name, os.Getenv(prefix+"_ACTION"), signalStr, os.Getenv(prefix+"_EXEC"), os.Getenv(prefix+"_ORDER"))
 		if err != nil {
-			return app, fmt.Errorf("specified CW_CLIENT_RESTART_DOCKER_CONTAINER but couldn't make docker api client (%s)", err)
+			return app, fmt.Errorf("invalid %s options (%s)", prefix, err)
+		}
+
+		app.cfg.DockerContainersToRestart = append(app.cfg.DockerContainersToRestart, container)
+	}
+
+	// CW_CLIENT_RESTART_DOCKER_LABEL (0... etc.)
+	app.cfg.DockerLabelFilters = []string{}
+	for i := 0; true; i++ {
+		labelFilter := os.Getenv("CW_CLIENT_RESTART_DOCKER_LABEL" + strconv.Itoa(i))
+		if labelFilter == "" {
+			// if next number not specified, done
+			break
 		}
 
-		testPingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancelPing()
-		_, err := app.dockerAPIClient.Ping(testPingCtx)
+		app.cfg.DockerLabelFilters = append(app.cfg.DockerLabelFilters, labelFilter)
+	}
+
+	if app.dockerUpdatesConfigured() {
+		err = app.configDockerAPIClient("CW_CLIENT_RESTART_DOCKER_CONTAINER or CW_CLIENT_RESTART_DOCKER_LABEL")
 		if err != nil {
-			app.logger.Errorf("specified CW_CLIENT_RESTART_DOCKER_CONTAINER but couldn't connect to docker api (%s), verify access to docker or restarts will not occur", err)
+			return app, err
 		}
 	}
 
@@ -300,6 +656,41 @@ func configureApp() (*app, error) {
 		app.logger.Warn("docker containers will only be stopped, not restarted, on cert file updates")
 	}
 
+	// CW_CLIENT_RESTART_DOCKER_MAX_CONCURRENT
+	dockerMaxConcurrentStr := os.Getenv("CW_CLIENT_RESTART_DOCKER_MAX_CONCURRENT")
+	app.cfg.DockerMaxConcurrent, err = strconv.Atoi(dockerMaxConcurrentStr)
+	if dockerMaxConcurrentStr == "" || err != nil || app.cfg.DockerMaxConcurrent < 0 {
+		app.logger.Debugf("CW_CLIENT_RESTART_DOCKER_MAX_CONCURRENT not specified or invalid, using default \"%d\"", defaultDockerMaxConcurrent)
+		app.cfg.DockerMaxConcurrent = defaultDockerMaxConcurrent
+	}
+
+	// CW_CLIENT_RESTART_DOCKER_WAIT_HEALTHY
+	dockerWaitHealthyStr := os.Getenv("CW_CLIENT_RESTART_DOCKER_WAIT_HEALTHY")
+	if dockerWaitHealthyStr == "true" {
+		app.cfg.DockerWaitHealthy = true
+	} else if dockerWaitHealthyStr == "false" {
+		app.cfg.DockerWaitHealthy = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_RESTART_DOCKER_WAIT_HEALTHY not specified or invalid, using default \"%t\"", defaultDockerWaitHealthy)
+		app.cfg.DockerWaitHealthy = defaultDockerWaitHealthy
+	}
+
+	// CW_CLIENT_RESTART_DOCKER_HEALTH_TIMEOUT
+	dockerHealthTimeoutStr := os.Getenv("CW_CLIENT_RESTART_DOCKER_HEALTH_TIMEOUT")
+	app.cfg.DockerHealthTimeoutSeconds, err = strconv.Atoi(dockerHealthTimeoutStr)
+	if dockerHealthTimeoutStr == "" || err != nil || app.cfg.DockerHealthTimeoutSeconds <= 0 {
+		app.logger.Debugf("CW_CLIENT_RESTART_DOCKER_HEALTH_TIMEOUT not specified or invalid, using default \"%d\"", defaultDockerHealthTimeoutSeconds)
+		app.cfg.DockerHealthTimeoutSeconds = defaultDockerHealthTimeoutSeconds
+	}
+
+	// CW_CLIENT_RESTART_DOCKER_RUNNING_SECONDS
+	dockerRunningSecondsStr := os.Getenv("CW_CLIENT_RESTART_DOCKER_RUNNING_SECONDS")
+	app.cfg.DockerRunningSeconds, err = strconv.Atoi(dockerRunningSecondsStr)
+	if dockerRunningSecondsStr == "" || err != nil || app.cfg.DockerRunningSeconds < 0 {
+		app.logger.Debugf("CW_CLIENT_RESTART_DOCKER_RUNNING_SECONDS not specified or invalid, using default \"%d\"", defaultDockerRunningSeconds)
+		app.cfg.DockerRunningSeconds = defaultDockerRunningSeconds
+	}
+
 	// CW_CLIENT_BIND_ADDRESS
 	app.cfg.BindAddress = os.Getenv("CW_CLIENT_BIND_ADDRESS")
 	if app.cfg.BindAddress == "" {
@@ -344,6 +735,53 @@ func configureApp() (*app, error) {
 		app.cfg.CertPermissions = fs.FileMode(certPermInt)
 	}
 
+	// CW_CLIENT_KEY_FORMAT
+	app.cfg.KeyFormat, err = parseKeyFormat(os.Getenv("CW_CLIENT_KEY_FORMAT"))
+	if err != nil {
+		return app, fmt.Errorf("CW_CLIENT_KEY_FORMAT %s", err)
+	}
+
+	// CW_CLIENT_FILE_VERSIONED_SET
+	fileVersionedSet := os.Getenv("CW_CLIENT_FILE_VERSIONED_SET")
+	if fileVersionedSet == "true" {
+		app.cfg.FileVersionedSet = true
+	} else if fileVersionedSet == "false" {
+		app.cfg.FileVersionedSet = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_FILE_VERSIONED_SET not specified or invalid, using default \"%t\"", defaultFileVersionedSet)
+		app.cfg.FileVersionedSet = defaultFileVersionedSet
+	}
+
+	// CW_CLIENT_ARCHIVE_COUNT
+	archiveCount := os.Getenv("CW_CLIENT_ARCHIVE_COUNT")
+	app.cfg.ArchiveCount, err = strconv.Atoi(archiveCount)
+	if archiveCount == "" || err != nil || app.cfg.ArchiveCount < 0 {
+		app.logger.Debugf("CW_CLIENT_ARCHIVE_COUNT not specified or invalid, using default \"%d\"", defaultArchiveCount)
+		app.cfg.ArchiveCount = defaultArchiveCount
+	}
+
+	// CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK
+	dockerAutoRollbackStr := os.Getenv("CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK")
+	if dockerAutoRollbackStr == "true" {
+		app.cfg.DockerAutoRollback = true
+	} else if dockerAutoRollbackStr == "false" {
+		app.cfg.DockerAutoRollback = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK not specified or invalid, using default \"%t\"", defaultDockerAutoRollback)
+		app.cfg.DockerAutoRollback = defaultDockerAutoRollback
+	}
+	if app.cfg.DockerAutoRollback {
+		// rollback needs container health and the previous files
+		if !app.cfg.DockerWaitHealthy {
+			app.logger.Info("CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK enabled, also enabling CW_CLIENT_RESTART_DOCKER_WAIT_HEALTHY")
+			app.cfg.DockerWaitHealthy = true
+		}
+		if app.cfg.ArchiveCount < 2 {
+			app.logger.Info("CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK enabled, setting CW_CLIENT_ARCHIVE_COUNT to 2")
+			app.cfg.ArchiveCount = 2
+		}
+	}
+
 	// CW_CLIENT_PFX_CREATE
 	pfxCreate := os.Getenv("CW_CLIENT_PFX_CREATE")
 	if pfxCreate == "true" {
@@ -400,6 +838,963 @@ func configureApp() (*app, error) {
 		}
 	}
 
+	// CW_CLIENT_PFX (0... etc.)
+	app.cfg.PfxOutputs = []pfxOutputConfig{}
+	for i := 0; true; i++ {
+		pfxEnvPrefix := "CW_CLIENT_PFX" + strconv.Itoa(i)
+
+		pfxOutput := pfxOutputConfig{
+			Filename: os.Getenv(pfxEnvPrefix + "_FILENAME"),
+			Options: pfxOptions{
+				Encoding:     strings.ToLower(os.Getenv(pfxEnvPrefix + "_ENCODING")),
+				Password:     os.Getenv(pfxEnvPrefix + "_PASSWORD"),
+				FriendlyName: os.Getenv(pfxEnvPrefix + "_FRIENDLY_NAME"),
+			},
+		}
+		if pfxOutput.Filename == "" {
+			// if next number not specified, done
+			break
+		}
+
+		if pfxOutput.Options.Encoding == "" {
+			pfxOutput.Options.Encoding = defaultPFXOutputEncoding
+		} else if _, ok := pfxEncoders[pfxOutput.Options.Encoding]; !ok {
+			return app, fmt.Errorf("%s_ENCODING \"%s\" is not valid", pfxEnvPrefix, pfxOutput.Options.Encoding)
+		}
+		if pfxOutput.Options.Encoding == "passwordless" && pfxOutput.Options.Password != "" {
+			return app, fmt.Errorf("%s_PASSWORD must be blank when using passwordless encoding", pfxEnvPrefix)
+		}
+
+		caOnly := os.Getenv(pfxEnvPrefix + "_CA_ONLY")
+		if caOnly == "true" {
+			pfxOutput.Options.CAOnly = true
+		} else if caOnly != "" && caOnly != "false" {
+			return app, fmt.Errorf("%s_CA_ONLY must be true or false", pfxEnvPrefix)
+		}
+
+		// the pkcs12 lib can only set friendly names on trust store entries
+		if pfxOutput.Options.FriendlyName != "" && !pfxOutput.Options.CAOnly {
+			return app, fmt.Errorf("%s_FRIENDLY_NAME is only supported when %s_CA_ONLY is true", pfxEnvPrefix, pfxEnvPrefix)
+		}
+
+		app.cfg.PfxOutputs = append(app.cfg.PfxOutputs, pfxOutput)
+	}
+
+	// CW_CLIENT_CA_BUNDLE_CREATE
+	caBundleCreate := os.Getenv("CW_CLIENT_CA_BUNDLE_CREATE")
+	if caBundleCreate == "true" {
+		app.cfg.CABundleCreate = true
+	} else if caBundleCreate == "false" {
+		app.cfg.CABundleCreate = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_CA_BUNDLE_CREATE not specified or invalid, using default \"%t\"", defaultCABundleCreate)
+		app.cfg.CABundleCreate = defaultCABundleCreate
+	}
+
+	if app.cfg.CABundleCreate {
+		// CW_CLIENT_CA_BUNDLE_FILENAME
+		app.cfg.CABundleFilename = os.Getenv("CW_CLIENT_CA_BUNDLE_FILENAME")
+		if app.cfg.CABundleFilename == "" {
+			app.logger.Debugf("CW_CLIENT_CA_BUNDLE_FILENAME not specified, using default \"%s\"", defaultCABundleFilename)
+			app.cfg.CABundleFilename = defaultCABundleFilename
+		}
+
+		// CW_CLIENT_CA_BUNDLE_ROOT_FILE
+		caBundleRootFile := os.Getenv("CW_CLIENT_CA_BUNDLE_ROOT_FILE")
+		if caBundleRootFile != "" {
+			app.cfg.CABundleRootPem, err = os.ReadFile(caBundleRootFile)
+			if err != nil {
+				return app, fmt.Errorf("failed to read CW_CLIENT_CA_BUNDLE_ROOT_FILE (%s)", err)
+			}
+			roots, err := pemToCerts(app.cfg.CABundleRootPem)
+			if err != nil || len(roots) == 0 {
+				return app, errors.New("CW_CLIENT_CA_BUNDLE_ROOT_FILE does not contain any valid pem certificates")
+			}
+		}
+
+		// CW_CLIENT_CA_BUNDLE_ROOT_FROM_SYSTEM
+		caBundleRootFromSystem := os.Getenv("CW_CLIENT_CA_BUNDLE_ROOT_FROM_SYSTEM")
+		if caBundleRootFromSystem == "true" {
+			app.cfg.CABundleRootFromSystem = true
+		} else if caBundleRootFromSystem == "false" {
+			app.cfg.CABundleRootFromSystem = false
+		} else {
+			app.logger.Debugf("CW_CLIENT_CA_BUNDLE_ROOT_FROM_SYSTEM not specified or invalid, using default \"%t\"", defaultCABundleRootFromSystem)
+			app.cfg.CABundleRootFromSystem = defaultCABundleRootFromSystem
+		}
+	}
+
+	// CW_CLIENT_TRAEFIK_CONFIG_CREATE
+	traefikConfigCreate := os.Getenv("CW_CLIENT_TRAEFIK_CONFIG_CREATE")
+	if traefikConfigCreate == "true" {
+		app.cfg.TraefikConfigCreate = true
+	} else if traefikConfigCreate == "false" {
+		app.cfg.TraefikConfigCreate = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_TRAEFIK_CONFIG_CREATE not specified or invalid, using default \"%t\"", defaultTraefikConfigCreate)
+		app.cfg.TraefikConfigCreate = defaultTraefikConfigCreate
+	}
+
+	if app.cfg.TraefikConfigCreate {
+		// CW_CLIENT_TRAEFIK_CONFIG_FILENAME
+		app.cfg.TraefikConfigFilename = os.Getenv("CW_CLIENT_TRAEFIK_CONFIG_FILENAME")
+		if app.cfg.TraefikConfigFilename == "" {
+			app.logger.Debugf("CW_CLIENT_TRAEFIK_CONFIG_FILENAME not specified, using default \"%s\"", defaultTraefikConfigFilename)
+			app.cfg.TraefikConfigFilename = defaultTraefikConfigFilename
+		}
+
+		// CW_CLIENT_TRAEFIK_CONFIG_FORMAT
+		app.cfg.TraefikConfigOptions.Format = strings.ToLower(os.Getenv("CW_CLIENT_TRAEFIK_CONFIG_FORMAT"))
+		if app.cfg.TraefikConfigOptions.Format == "" {
+			app.cfg.TraefikConfigOptions.Format = traefikFormatYAML
+			if strings.HasSuffix(strings.ToLower(app.cfg.TraefikConfigFilename), ".toml") {
+				app.cfg.TraefikConfigOptions.Format = traefikFormatTOML
+			}
+			app.logger.Debugf("CW_CLIENT_TRAEFIK_CONFIG_FORMAT not specified, using \"%s\" based on filename", app.cfg.TraefikConfigOptions.Format)
+		} else if app.cfg.TraefikConfigOptions.Format == "yml" {
+			app.cfg.TraefikConfigOptions.Format = traefikFormatYAML
+		}
+		if app.cfg.TraefikConfigOptions.Format != traefikFormatYAML && app.cfg.TraefikConfigOptions.Format != traefikFormatTOML {
+			return app, errors.New("CW_CLIENT_TRAEFIK_CONFIG_FORMAT must be yaml or toml")
+		}
+
+		// CW_CLIENT_TRAEFIK_CONFIG_CERT_PATH
+		app.cfg.TraefikConfigOptions.CertPath = os.Getenv("CW_CLIENT_TRAEFIK_CONFIG_CERT_PATH")
+		if app.cfg.TraefikConfigOptions.CertPath == "" {
+			app.logger.Debugf("CW_CLIENT_TRAEFIK_CONFIG_CERT_PATH not specified, using CW_CLIENT_CERT_PATH \"%s\"", app.cfg.CertStoragePath)
+			app.cfg.TraefikConfigOptions.CertPath = app.cfg.CertStoragePath
+		}
+
+		// CW_CLIENT_TRAEFIK_CONFIG_DEFAULT_CERT
+		traefikConfigDefaultCert := os.Getenv("CW_CLIENT_TRAEFIK_CONFIG_DEFAULT_CERT")
+		if traefikConfigDefaultCert == "true" {
+			app.cfg.TraefikConfigOptions.DefaultCert = true
+		} else if traefikConfigDefaultCert == "false" {
+			app.cfg.TraefikConfigOptions.DefaultCert = false
+		} else {
+			app.logger.Debugf("CW_CLIENT_TRAEFIK_CONFIG_DEFAULT_CERT not specified or invalid, using default \"%t\"", defaultTraefikConfigDefaultCert)
+			app.cfg.TraefikConfigOptions.DefaultCert = defaultTraefikConfigDefaultCert
+		}
+
+		if app.dockerUpdatesConfigured() {
+			app.logger.Infof("CW_CLIENT_TRAEFIK_CONFIG_CREATE is enabled and docker containers are updated on file changes, make sure traefik is not one of them (it doesn't need a restart to load new certs)")
+		}
+	}
+
+	// CW_CLIENT_COMBINED_PEM_CREATE
+	combinedPemCreate := os.Getenv("CW_CLIENT_COMBINED_PEM_CREATE")
+	if combinedPemCreate == "true" {
+		app.cfg.CombinedPemCreate = true
+	} else if combinedPemCreate == "false" {
+		app.cfg.CombinedPemCreate = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_COMBINED_PEM_CREATE not specified or invalid, using default \"%t\"", defaultCombinedPemCreate)
+		app.cfg.CombinedPemCreate = defaultCombinedPemCreate
+	}
+
+	if app.cfg.CombinedPemCreate {
+		// CW_CLIENT_COMBINED_PEM_FILENAME
+		app.cfg.CombinedPemFilename = os.Getenv("CW_CLIENT_COMBINED_PEM_FILENAME")
+		if app.cfg.CombinedPemFilename == "" {
+			app.logger.Debugf("CW_CLIENT_COMBINED_PEM_FILENAME not specified, using default \"%s\"", defaultCombinedPemFilename)
+			app.cfg.CombinedPemFilename = defaultCombinedPemFilename
+		}
+	}
+
+	// CW_CLIENT_KEY_OUTPUT (0... etc.)
+	app.cfg.KeyOutputs = []keyOutputConfig{}
+	for i := 0; true; i++ {
+		keyOutput := keyOutputConfig{
+			Filename: os.Getenv("CW_CLIENT_KEY_OUTPUT" + strconv.Itoa(i) + "_FILENAME"),
+		}
+		if keyOutput.Filename == "" {
+			// if next number not specified, done
+			break
+		}
+
+		keyOutput.Format, err = parseKeyFormat(os.Getenv("CW_CLIENT_KEY_OUTPUT" + strconv.Itoa(i) + "_FORMAT"))
+		if err != nil || keyOutput.Format == keyFormatUnchanged {
+			return app, fmt.Errorf("CW_CLIENT_KEY_OUTPUT%d_FORMAT must be one of %s, %s, or %s", i, keyFormatPKCS1, keyFormatSEC1, keyFormatPKCS8)
+		}
+
+		app.cfg.KeyOutputs = append(app.cfg.KeyOutputs, keyOutput)
+	}
+
+	// CW_CLIENT_KEY_ENCRYPTED_CREATE
+	keyEncryptedCreate := os.Getenv("CW_CLIENT_KEY_ENCRYPTED_CREATE")
+	if keyEncryptedCreate == "true" {
+		app.cfg.KeyEncryptedCreate = true
+	} else if keyEncryptedCreate == "false" {
+		app.cfg.KeyEncryptedCreate = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_KEY_ENCRYPTED_CREATE not specified or invalid, using default \"%t\"", defaultKeyEncryptedCreate)
+		app.cfg.KeyEncryptedCreate = defaultKeyEncryptedCreate
+	}
+
+	if app.cfg.KeyEncryptedCreate {
+		// CW_CLIENT_KEY_ENCRYPTED_FILENAME
+		app.cfg.KeyEncryptedFilename = os.Getenv("CW_CLIENT_KEY_ENCRYPTED_FILENAME")
+		if app.cfg.KeyEncryptedFilename == "" {
+			app.logger.Debugf("CW_CLIENT_KEY_ENCRYPTED_FILENAME not specified, using default \"%s\"", defaultKeyEncryptedFilename)
+			app.cfg.KeyEncryptedFilename = defaultKeyEncryptedFilename
+		}
+
+		// CW_CLIENT_KEY_ENCRYPTED_PASSWORD / CW_CLIENT_KEY_ENCRYPTED_PASSWORD_FILE
+		app.cfg.KeyEncryptedPassword, err = getEnvOrFile("CW_CLIENT_KEY_ENCRYPTED_PASSWORD")
+		if err != nil {
+			return app, err
+		}
+		if app.cfg.KeyEncryptedPassword == "" {
+			return app, errors.New("CW_CLIENT_KEY_ENCRYPTED_CREATE is enabled but no password was specified")
+		}
+
+		// CW_CLIENT_KEY_ENCRYPTED_CIPHER
+		keyEncryptedCipher := os.Getenv("CW_CLIENT_KEY_ENCRYPTED_CIPHER")
+		if keyEncryptedCipher == "" {
+			app.logger.Debugf("CW_CLIENT_KEY_ENCRYPTED_CIPHER not specified, using default \"%s\"", defaultKeyEncryptedCipher)
+			keyEncryptedCipher = defaultKeyEncryptedCipher
+		}
+
+		// CW_CLIENT_KEY_ENCRYPTED_KDF
+		keyEncryptedKDF := os.Getenv("CW_CLIENT_KEY_ENCRYPTED_KDF")
+		if keyEncryptedKDF == "" {
+			app.logger.Debugf("CW_CLIENT_KEY_ENCRYPTED_KDF not specified, using default \"%s\"", defaultKeyEncryptedKDF)
+			keyEncryptedKDF = defaultKeyEncryptedKDF
+		}
+
+		app.cfg.KeyEncryptedOpts, err = parseEncryptedKeyOpts(keyEncryptedCipher, keyEncryptedKDF)
+		if err != nil {
+			return app, fmt.Errorf("invalid encrypted key config (%s)", err)
+		}
+	}
+
+	// CW_CLIENT_OCSP_STAPLE_CREATE
+	ocspStapleCreate := os.Getenv("CW_CLIENT_OCSP_STAPLE_CREATE")
+	if ocspStapleCreate == "true" {
+		app.cfg.OCSPStapleCreate = true
+	} else if ocspStapleCreate == "false" {
+		app.cfg.OCSPStapleCreate = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_OCSP_STAPLE_CREATE not specified or invalid, using default \"%t\"", defaultOCSPStapleCreate)
+		app.cfg.OCSPStapleCreate = defaultOCSPStapleCreate
+	}
+
+	// CW_CLIENT_OCSP_RESPONDER_URL
+	app.cfg.OCSPResponderURL = os.Getenv("CW_CLIENT_OCSP_RESPONDER_URL")
+	if app.cfg.OCSPResponderURL != "" && !strings.HasPrefix(app.cfg.OCSPResponderURL, "http://") && !strings.HasPrefix(app.cfg.OCSPResponderURL, "https://") {
+		return app, errors.New("CW_CLIENT_OCSP_RESPONDER_URL must start with http:// or https://")
+	}
+
+	if app.cfg.OCSPStapleCreate {
+		// CW_CLIENT_OCSP_STAPLE_FILENAME
+		app.cfg.OCSPStapleFilename = os.Getenv("CW_CLIENT_OCSP_STAPLE_FILENAME")
+		if app.cfg.OCSPStapleFilename == "" {
+			app.logger.Debugf("CW_CLIENT_OCSP_STAPLE_FILENAME not specified, using default \"%s\"", defaultOCSPStapleFilename)
+			app.cfg.OCSPStapleFilename = defaultOCSPStapleFilename
+		}
+
+		// CW_CLIENT_OCSP_STAPLE_RESTART_CONTAINERS
+		ocspStapleRestart := os.Getenv("CW_CLIENT_OCSP_STAPLE_RESTART_CONTAINERS")
+		if ocspStapleRestart == "true" {
+			app.cfg.OCSPStapleRestartContainers = true
+		} else if ocspStapleRestart == "false" {
+			app.cfg.OCSPStapleRestartContainers = false
+		} else {
+			app.logger.Debugf("CW_CLIENT_OCSP_STAPLE_RESTART_CONTAINERS not specified or invalid, using default \"%t\"", defaultOCSPStapleRestartContainers)
+			app.cfg.OCSPStapleRestartContainers = defaultOCSPStapleRestartContainers
+		}
+
+		// file writes request a refresh, so this must exist before any job is scheduled
+		app.ocspStapleFileRefresh = make(chan struct{}, 1)
+	}
+
+	// CW_CLIENT_OCSP_HTTPS_STAPLING
+	ocspHttpsStapling := os.Getenv("CW_CLIENT_OCSP_HTTPS_STAPLING")
+	if ocspHttpsStapling == "true" {
+		app.cfg.OCSPHttpsStapling = true
+	} else if ocspHttpsStapling == "false" {
+		app.cfg.OCSPHttpsStapling = false
+	} else {
+		app.logger.Debugf("CW_CLIENT_OCSP_HTTPS_STAPLING not specified or invalid, using default \"%t\"", defaultOCSPHttpsStapling)
+		app.cfg.OCSPHttpsStapling = defaultOCSPHttpsStapling
+	}
+	if app.cfg.OCSPHttpsStapling {
+		// cert updates request a refresh, so this must exist before any job is scheduled
+		app.httpsOCSPStapleRefresh = make(chan struct{}, 1)
+	}
+
+	// CW_CLIENT_SDS_LISTEN
+	app.cfg.SDSListen = os.Getenv("CW_CLIENT_SDS_LISTEN")
+	if app.cfg.SDSListen != "" {
+		_, _, err = parseSDSListen(app.cfg.SDSListen)
+		if err != nil {
+			return app, fmt.Errorf("invalid CW_CLIENT_SDS_LISTEN (%s)", err)
+		}
+
+		// CW_CLIENT_SDS_CERT_SECRET_NAME
+		app.cfg.SDSCertSecretName = os.Getenv("CW_CLIENT_SDS_CERT_SECRET_NAME")
+		if app.cfg.SDSCertSecretName == "" {
+			app.logger.Debugf("CW_CLIENT_SDS_CERT_SECRET_NAME not specified, using default \"%s\"", defaultSDSCertSecretName)
+			app.cfg.SDSCertSecretName = defaultSDSCertSecretName
+		}
+
+		// CW_CLIENT_SDS_VALIDATION_SECRET_NAME
+		app.cfg.SDSValidationSecretName = os.Getenv("CW_CLIENT_SDS_VALIDATION_SECRET_NAME")
+		if app.cfg.SDSValidationSecretName == "" {
+			app.logger.Debugf("CW_CLIENT_SDS_VALIDATION_SECRET_NAME not specified, using default \"%s\"", defaultSDSValidationSecretName)
+			app.cfg.SDSValidationSecretName = defaultSDSValidationSecretName
+		}
+
+		if app.cfg.SDSCertSecretName == app.cfg.SDSValidationSecretName {
+			return app, errors.New("CW_CLIENT_SDS_CERT_SECRET_NAME and CW_CLIENT_SDS_VALIDATION_SECRET_NAME must be different")
+		}
+
+		// cert updates set the secrets, so this must exist before any job is scheduled
+		app.sdsCache = envoyCache.NewLinearCache(envoyResource.SecretType)
+	}
+
+	// CW_CLIENT_KUBE_SECRET_NAME
+	app.cfg.KubeSecretName = os.Getenv("CW_CLIENT_KUBE_SECRET_NAME")
+	if app.cfg.KubeSecretName != "" {
+		// CW_CLIENT_KUBECONFIG
+		app.cfg.KubeConfigPath = os.Getenv("CW_CLIENT_KUBECONFIG")
+
+		var kubeClient *kubeClient
+		if app.cfg.KubeConfigPath != "" {
+			kubeClient, err = newKubeClientFromKubeConfig(app.cfg.KubeConfigPath)
+		} else {
+			app.logger.Debug("CW_CLIENT_KUBECONFIG not specified, using in-cluster service account")
+			kubeClient, err = newKubeClientInCluster()
+		}
+		if err != nil {
+			return app, fmt.Errorf("specified CW_CLIENT_KUBE_SECRET_NAME but couldn't make kubernetes api client (%s)", err)
+		}
+
+		// CW_CLIENT_KUBE_SECRET_NAMESPACE
+		app.cfg.KubeSecretNamespace = os.Getenv("CW_CLIENT_KUBE_SECRET_NAMESPACE")
+		if app.cfg.KubeSecretNamespace == "" {
+			app.logger.Debugf("CW_CLIENT_KUBE_SECRET_NAMESPACE not specified, using \"%s\"", kubeClient.namespace)
+			app.cfg.KubeSecretNamespace = kubeClient.namespace
+		}
+
+		// CW_CLIENT_KUBE_SECRET_INCLUDE_CA
+		kubeSecretIncludeCA := os.Getenv("CW_CLIENT_KUBE_SECRET_INCLUDE_CA")
+		if kubeSecretIncludeCA == "true" {
+			app.cfg.KubeSecretIncludeCA = true
+		} else if kubeSecretIncludeCA == "false" {
+			app.cfg.KubeSecretIncludeCA = false
+		} else {
+			app.logger.Debugf("CW_CLIENT_KUBE_SECRET_INCLUDE_CA not specified or invalid, using default \"%t\"", defaultKubeSecretIncludeCA)
+			app.cfg.KubeSecretIncludeCA = defaultKubeSecretIncludeCA
+		}
+
+		app.outputBackends = append(app.outputBackends, &kubeSecretOutput{
+			client:    kubeClient,
+			namespace: app.cfg.KubeSecretNamespace,
+			secret:    app.cfg.KubeSecretName,
+			includeCA: app.cfg.KubeSecretIncludeCA,
+			keyFormat: app.cfg.KeyFormat,
+		})
+	}
+
+	// CW_CLIENT_VAULT_ADDRESS
+	app.cfg.VaultAddress = strings.TrimSuffix(os.Getenv("CW_CLIENT_VAULT_ADDRESS"), "/")
+	if app.cfg.VaultAddress != "" {
+		if !strings.HasPrefix(app.cfg.VaultAddress, "http://") && !strings.HasPrefix(app.cfg.VaultAddress, "https://") {
+			return app, errors.New("CW_CLIENT_VAULT_ADDRESS must start with http:// or https://")
+		}
+
+		vaultClient := &vaultClient{
+			address:   app.cfg.VaultAddress,
+			namespace: os.Getenv("CW_CLIENT_VAULT_NAMESPACE"),
+		}
+
+		// CW_CLIENT_VAULT_CA_FILE
+		vaultClient.httpClient, err = makeOutputBackendHttpClient(os.Getenv("CW_CLIENT_VAULT_CA_FILE"))
+		if err != nil {
+			return app, fmt.Errorf("invalid CW_CLIENT_VAULT_CA_FILE (%s)", err)
+		}
+
+		// CW_CLIENT_VAULT_KV_MOUNT
+		app.cfg.VaultKVMount = strings.Trim(os.Getenv("CW_CLIENT_VAULT_KV_MOUNT"), "/")
+		if app.cfg.VaultKVMount == "" {
+			app.logger.Debugf("CW_CLIENT_VAULT_KV_MOUNT not specified, using default \"%s\"", defaultVaultKVMount)
+			app.cfg.VaultKVMount = defaultVaultKVMount
+		}
+
+		// CW_CLIENT_VAULT_KV_PATH
+		app.cfg.VaultKVPath = strings.Trim(os.Getenv("CW_CLIENT_VAULT_KV_PATH"), "/")
+		if app.cfg.VaultKVPath == "" {
+			return app, errors.New("CW_CLIENT_VAULT_KV_PATH is required when CW_CLIENT_VAULT_ADDRESS is specified")
+		}
+
+		// CW_CLIENT_VAULT_TOKEN
+		vaultClient.token, err = getEnvOrFile("CW_CLIENT_VAULT_TOKEN")
+		if err != nil {
+			return app, err
+		}
+
+		// CW_CLIENT_VAULT_APPROLE_ROLE_ID
+		vaultClient.appRoleRoleID, err = getEnvOrFile("CW_CLIENT_VAULT_APPROLE_ROLE_ID")
+		if err != nil {
+			return app, err
+		}
+
+		// CW_CLIENT_VAULT_APPROLE_SECRET_ID
+		vaultClient.appRoleSecretID, err = getEnvOrFile("CW_CLIENT_VAULT_APPROLE_SECRET_ID")
+		if err != nil {
+			return app, err
+		}
+
+		if vaultClient.token != "" && vaultClient.appRoleRoleID != "" {
+			return app, errors.New("only one of CW_CLIENT_VAULT_TOKEN and CW_CLIENT_VAULT_APPROLE_ROLE_ID can be specified")
+		} else if vaultClient.token == "" && vaultClient.appRoleRoleID == "" {
+			return app, errors.New("CW_CLIENT_VAULT_TOKEN or CW_CLIENT_VAULT_APPROLE_ROLE_ID is required when CW_CLIENT_VAULT_ADDRESS is specified")
+		}
+
+		// CW_CLIENT_VAULT_APPROLE_MOUNT
+		if vaultClient.appRoleRoleID != "" {
+			vaultClient.appRoleMount = strings.Trim(os.Getenv("CW_CLIENT_VAULT_APPROLE_MOUNT"), "/")
+			if vaultClient.appRoleMount == "" {
+				app.logger.Debugf("CW_CLIENT_VAULT_APPROLE_MOUNT not specified, using default \"%s\"", defaultVaultAppRoleMount)
+				vaultClient.appRoleMount = defaultVaultAppRoleMount
+			}
+		}
+
+		// CW_CLIENT_VAULT_PFX_CREATE
+		vaultPfxCreate := os.Getenv("CW_CLIENT_VAULT_PFX_CREATE")
+		if vaultPfxCreate == "true" {
+			app.cfg.VaultPfxCreate = true
+		} else if vaultPfxCreate == "false" {
+			app.cfg.VaultPfxCreate = false
+		} else {
+			app.logger.Debugf("CW_CLIENT_VAULT_PFX_CREATE not specified or invalid, using default \"%t\"", defaultVaultPfxCreate)
+			app.cfg.VaultPfxCreate = defaultVaultPfxCreate
+		}
+
+		// CW_CLIENT_VAULT_PFX_PASSWORD
+		vaultPfxPassword, err := getEnvOrFile("CW_CLIENT_VAULT_PFX_PASSWORD")
+		if err != nil {
+			return app, err
+		}
+
+		app.outputBackends = append(app.outputBackends, &vaultKVOutput{
+			client:     vaultClient,
+			mount:      app.cfg.VaultKVMount,
+			path:       app.cfg.VaultKVPath,
+			keyFormat:  app.cfg.KeyFormat,
+			pfxCreate:  app.cfg.VaultPfxCreate,
+			pfxOptions: pfxOptions{Encoding: "modern", Password: vaultPfxPassword},
+		})
+	}
+
+	// CW_CLIENT_S3_ENDPOINT
+	app.cfg.S3Endpoint = os.Getenv("CW_CLIENT_S3_ENDPOINT")
+	if app.cfg.S3Endpoint != "" {
+		s3Endpoint, err := url.Parse(app.cfg.S3Endpoint)
+		if err != nil || (s3Endpoint.Scheme != "http" && s3Endpoint.Scheme != "https") || s3Endpoint.Host == "" {
+			return app, errors.New("CW_CLIENT_S3_ENDPOINT must be a url starting with http:// or https://")
+		}
+
+		s3Client := &s3Client{
+			endpoint: s3Endpoint,
+		}
+
+		// CW_CLIENT_S3_REGION
+		s3Client.region = os.Getenv("CW_CLIENT_S3_REGION")
+		if s3Client.region == "" {
+			app.logger.Debugf("CW_CLIENT_S3_REGION not specified, using default \"%s\"", defaultS3Region)
+			s3Client.region = defaultS3Region
+		}
+
+		// CW_CLIENT_S3_ACCESS_KEY_ID
+		s3Client.accessKeyID = os.Getenv("CW_CLIENT_S3_ACCESS_KEY_ID")
+		if s3Client.accessKeyID == "" {
+			return app, errors.New("CW_CLIENT_S3_ACCESS_KEY_ID is required when CW_CLIENT_S3_ENDPOINT is specified")
+		}
+
+		// CW_CLIENT_S3_SECRET_ACCESS_KEY
+		s3Client.secretAccessKey, err = getEnvOrFile("CW_CLIENT_S3_SECRET_ACCESS_KEY")
+		if err != nil {
+			return app, err
+		}
+		if s3Client.secretAccessKey == "" {
+			return app, errors.New("CW_CLIENT_S3_SECRET_ACCESS_KEY is required when CW_CLIENT_S3_ENDPOINT is specified")
+		}
+
+		// CW_CLIENT_S3_CA_FILE
+		s3Client.httpClient, err = makeOutputBackendHttpClient(os.Getenv("CW_CLIENT_S3_CA_FILE"))
+		if err != nil {
+			return app, fmt.Errorf("invalid CW_CLIENT_S3_CA_FILE (%s)", err)
+		}
+
+		// CW_CLIENT_S3_BUCKET
+		app.cfg.S3Bucket = os.Getenv("CW_CLIENT_S3_BUCKET")
+		if app.cfg.S3Bucket == "" {
+			return app, errors.New("CW_CLIENT_S3_BUCKET is required when CW_CLIENT_S3_ENDPOINT is specified")
+		}
+
+		// CW_CLIENT_S3_PREFIX
+		app.cfg.S3Prefix = strings.TrimPrefix(os.Getenv("CW_CLIENT_S3_PREFIX"), "/")
+
+		// CW_CLIENT_S3_FILES
+		s3Files := os.Getenv("CW_CLIENT_S3_FILES")
+		if s3Files == "" {
+			app.logger.Debugf("CW_CLIENT_S3_FILES not specified, using default \"%s\"", defaultS3Files)
+			s3Files = defaultS3Files
+		}
+		app.cfg.S3Files = strings.Fields(s3Files)
+		for _, filename := range app.cfg.S3Files {
+			if !app.isOutputFilename(filename) {
+				return app, fmt.Errorf("CW_CLIENT_S3_FILES contains %s which is not a configured output", filename)
+			}
+		}
+
+		// CW_CLIENT_S3_SSE
+		s3SSE := os.Getenv("CW_CLIENT_S3_SSE")
+		if s3SSE != "" && s3SSE != "AES256" && s3SSE != "aws:kms" {
+			return app, errors.New("CW_CLIENT_S3_SSE must be AES256 or aws:kms")
+		}
+
+		app.outputBackends = append(app.outputBackends, &s3Output{
+			client:    s3Client,
+			bucket:    app.cfg.S3Bucket,
+			prefix:    app.cfg.S3Prefix,
+			filenames: app.cfg.S3Files,
+			makeContent: func(filename string, keyPem, certPem []byte) ([]byte, error) {
+				data, _, err := app.makeOutputContent(filename, keyPem, certPem)
+				return data, err
+			},
+			outputConfig: app.outputConfig(app.cfg.S3Files),
+			sse:          s3SSE,
+			sseKMSKeyID:  os.Getenv("CW_CLIENT_S3_SSE_KMS_KEY_ID"),
+		})
+	}
+
+	// CW_CLIENT_SFTP_KNOWN_HOSTS
+	sftpKnownHostsDefault := os.Getenv("CW_CLIENT_SFTP_KNOWN_HOSTS")
+	if sftpKnownHostsDefault == "" {
+		sftpKnownHostsDefault = defaultSftpKnownHosts
+	}
+
+	// CW_CLIENT_SFTP (0... etc.)
+	for i := 0; true; i++ {
+		prefix := "CW_CLIENT_SFTP" + strconv.Itoa(i)
+
+		host := os.Getenv(prefix + "_HOST")
+		if host == "" {
+			// if next number not specified, done
+			break
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, defaultSftpPort)
+		}
+
+		user := os.Getenv(prefix + "_USER")
+		if user == "" {
+			return app, fmt.Errorf("%s_USER is required", prefix)
+		}
+
+		keyFile := os.Getenv(prefix + "_KEY_FILE")
+		if keyFile == "" {
+			return app, fmt.Errorf("%s_KEY_FILE is required", prefix)
+		}
+
+		keyPassphrase, err := getEnvOrFile(prefix + "_KEY_PASSPHRASE")
+		if err != nil {
+			return app, err
+		}
+
+		knownHosts := os.Getenv(prefix + "_KNOWN_HOSTS")
+		if knownHosts == "" {
+			app.logger.Debugf("%s_KNOWN_HOSTS not specified, using \"%s\"", prefix, sftpKnownHostsDefault)
+			knownHosts = sftpKnownHostsDefault
+		}
+
+		sshConfig, err := newSftpSSHConfig(user, keyFile, keyPassphrase, knownHosts)
+		if err != nil {
+			return app, fmt.Errorf("invalid %s ssh config (%s)", prefix, err)
+		}
+
+		remoteDir := os.Getenv(prefix + "_REMOTE_DIR")
+		if remoteDir == "" {
+			return app, fmt.Errorf("%s_REMOTE_DIR is required", prefix)
+		}
+
+		files := os.Getenv(prefix + "_FILES")
+		if files == "" {
+			app.logger.Debugf("%s_FILES not specified, using default \"%s\"", prefix, defaultSftpFiles)
+			files = defaultSftpFiles
+		}
+		filenames := strings.Fields(files)
+		for _, filename := range filenames {
+			if !app.isOutputFilename(filename) {
+				return app, fmt.Errorf("%s_FILES contains %s which is not a configured output", prefix, filename)
+			}
+		}
+
+		app.outputBackends = append(app.outputBackends, &sftpOutput{
+			host:          host,
+			sshConfig:     sshConfig,
+			remoteDir:     remoteDir,
+			reloadCommand: os.Getenv(prefix + "_RELOAD_COMMAND"),
+			filenames:     filenames,
+			makeContent:   app.makeOutputContent,
+			outputConfig:  app.outputConfig(filenames),
+		})
+	}
+
+	// CW_CLIENT_DOCKER_COPY_PATH
+	app.cfg.DockerCopyPath = os.Getenv("CW_CLIENT_DOCKER_COPY_PATH")
+	if app.cfg.DockerCopyPath != "" {
+		if !path.IsAbs(app.cfg.DockerCopyPath) {
+			return app, errors.New("CW_CLIENT_DOCKER_COPY_PATH must be an absolute path")
+		}
+		if !app.dockerUpdatesConfigured() {
+			return app, errors.New("CW_CLIENT_DOCKER_COPY_PATH requires containers to be specified using CW_CLIENT_RESTART_DOCKER_CONTAINER or CW_CLIENT_RESTART_DOCKER_LABEL")
+		}
+
+		// CW_CLIENT_DOCKER_COPY_FILES
+		files := os.Getenv("CW_CLIENT_DOCKER_COPY_FILES")
+		if files == "" {
+			app.logger.Debugf("CW_CLIENT_DOCKER_COPY_FILES not specified, using default \"%s\"", defaultDockerCopyFiles)
+			files = defaultDockerCopyFiles
+		}
+		filenames := strings.Fields(files)
+		for _, filename := range filenames {
+			if !app.isOutputFilename(filename) {
+				return app, fmt.Errorf("CW_CLIENT_DOCKER_COPY_FILES contains %s which is not a configured output", filename)
+			}
+		}
+
+		// CW_CLIENT_DOCKER_COPY_OWNER
+		owner := os.Getenv("CW_CLIENT_DOCKER_COPY_OWNER")
+		if owner == "" {
+			app.logger.Debugf("CW_CLIENT_DOCKER_COPY_OWNER not specified, using default \"%s\"", defaultDockerCopyOwner)
+			owner = defaultDockerCopyOwner
+		}
+		uidStr, gidStr, _ := strings.Cut(owner, ":")
+		uid, uidErr := strconv.Atoi(uidStr)
+		gid, gidErr := strconv.Atoi(gidStr)
+		if uidErr != nil || gidErr != nil || uid < 0 || gid < 0 {
+			return app, fmt.Errorf("CW_CLIENT_DOCKER_COPY_OWNER %s is invalid (must be uid:gid)", owner)
+		}
+
+		app.outputBackends = append(app.outputBackends, &dockerCopyOutput{
+			client:       app.dockerAPIClient,
+			containers:   app.dockerContainersToUpdate,
+			dir:          app.cfg.DockerCopyPath,
+			uid:          uid,
+			gid:          gid,
+			filenames:    filenames,
+			makeContent:  app.makeOutputContent,
+			outputConfig: app.outputConfig(filenames),
+		})
+	}
+
+	// CW_CLIENT_DOCKER_SWARM_SERVICE (0... etc.)
+	app.cfg.DockerSwarmServices = []string{}
+	for i := 0; true; i++ {
+		service := os.Getenv("CW_CLIENT_DOCKER_SWARM_SERVICE" + strconv.Itoa(i))
+		if service == "" {
+			// if next number not specified, done
+			break
+		}
+
+		app.cfg.DockerSwarmServices = append(app.cfg.DockerSwarmServices, service)
+	}
+
+	// CW_CLIENT_DOCKER_SWARM_SECRET (0... etc.)
+	swarmItems := []dockerSwarmItem{}
+	for i := 0; true; i++ {
+		prefix := "CW_CLIENT_DOCKER_SWARM_SECRET" + strconv.Itoa(i)
+
+		item := dockerSwarmItem{
+			name: os.Getenv(prefix + "_NAME"),
+		}
+		if item.name == "" {
+			// if next number not specified, done
+			break
+		}
+		if len(item.name)+dockerSwarmVersionSuffixLen > dockerSwarmMaxNameLen {
+			return app, fmt.Errorf("%s_NAME %s is too long, it can be at most %d characters (docker's limit is %d including the version suffix)",
+				prefix, item.name, dockerSwarmMaxNameLen-dockerSwarmVersionSuffixLen, dockerSwarmMaxNameLen)
+		}
+		for _, other := range swarmItems {
+			if other.name == item.name {
+				return app, fmt.Errorf("%s_NAME %s is used more than once", prefix, item.name)
+			}
+		}
+
+		item.filename = os.Getenv(prefix + "_FILE")
+		if !app.isOutputFilename(item.filename) {
+			return app, fmt.Errorf("%s_FILE %s is not a configured output", prefix, item.filename)
+		}
+
+		item.config = os.Getenv(prefix+"_CONFIG") == "true"
+
+		swarmItems = append(swarmItems, item)
+	}
+
+	if len(swarmItems) > 0 || len(app.cfg.DockerSwarmServices) > 0 {
+		if len(swarmItems) == 0 || len(app.cfg.DockerSwarmServices) == 0 {
+			return app, errors.New("docker swarm rotation requires both CW_CLIENT_DOCKER_SWARM_SECRET0_NAME and CW_CLIENT_DOCKER_SWARM_SERVICE0")
+		}
+
+		err = app.configDockerAPIClient("CW_CLIENT_DOCKER_SWARM_SERVICE")
+		if err != nil {
+			return app, err
+		}
+
+		swarmFilenames := []string{}
+		for _, item := range swarmItems {
+			swarmFilenames = append(swarmFilenames, item.filename)
+		}
+
+		app.outputBackends = append(app.outputBackends, &dockerSwarmOutput{
+			client:       app.dockerAPIClient,
+			items:        swarmItems,
+			services:     app.cfg.DockerSwarmServices,
+			makeContent:  app.makeOutputContent,
+			outputConfig: app.outputConfig(swarmFilenames),
+			logger:       app.logger,
+		})
+	}
+
+	// CW_CLIENT_CONSUL_ADDRESS
+	app.cfg.ConsulAddress = strings.TrimSuffix(os.Getenv("CW_CLIENT_CONSUL_ADDRESS"), "/")
+	if app.cfg.ConsulAddress != "" {
+		if !strings.HasPrefix(app.cfg.ConsulAddress, "http://") && !strings.HasPrefix(app.cfg.ConsulAddress, "https://") {
+			return app, errors.New("CW_CLIENT_CONSUL_ADDRESS must start with http:// or https://")
+		}
+
+		consulKV := &consulKV{
+			address: app.cfg.ConsulAddress,
+		}
+
+		// CW_CLIENT_CONSUL_TOKEN
+		consulKV.token, err = getEnvOrFile("CW_CLIENT_CONSUL_TOKEN")
+		if err != nil {
+			return app, err
+		}
+
+		// CW_CLIENT_CONSUL_CA_FILE
+		consulKV.httpClient, err = makeOutputBackendHttpClient(os.Getenv("CW_CLIENT_CONSUL_CA_FILE"))
+		if err != nil {
+			return app, fmt.Errorf("invalid CW_CLIENT_CONSUL_CA_FILE (%s)", err)
+		}
+
+		// CW_CLIENT_CONSUL_PREFIX
+		app.cfg.ConsulPrefix = strings.TrimPrefix(os.Getenv("CW_CLIENT_CONSUL_PREFIX"), "/")
+		if app.cfg.ConsulPrefix == "" {
+			return app, errors.New("CW_CLIENT_CONSUL_PREFIX is required when CW_CLIENT_CONSUL_ADDRESS is specified")
+		}
+		consulKV.prefix = app.cfg.ConsulPrefix
+
+		// CW_CLIENT_CONSUL_FILES
+		consulFiles := os.Getenv("CW_CLIENT_CONSUL_FILES")
+		if consulFiles == "" {
+			app.logger.Debugf("CW_CLIENT_CONSUL_FILES not specified, using default \"%s\"", defaultConsulFiles)
+			consulFiles = defaultConsulFiles
+		}
+		app.cfg.ConsulFiles = strings.Fields(consulFiles)
+		for _, filename := range app.cfg.ConsulFiles {
+			if !app.isOutputFilename(filename) {
+				return app, fmt.Errorf("CW_CLIENT_CONSUL_FILES contains %s which is not a configured output", filename)
+			}
+		}
+
+		app.outputBackends = append(app.outputBackends, &kvOutput{
+			storeName: "consul",
+			store:     consulKV,
+			prefix:    app.cfg.ConsulPrefix,
+			filenames: app.cfg.ConsulFiles,
+			makeContent: func(filename string, keyPem, certPem []byte) ([]byte, error) {
+				data, _, err := app.makeOutputContent(filename, keyPem, certPem)
+				return data, err
+			},
+			outputConfig: app.outputConfig(app.cfg.ConsulFiles),
+		})
+	}
+
+	// CW_CLIENT_ETCD_ADDRESS
+	app.cfg.EtcdAddress = strings.TrimSuffix(os.Getenv("CW_CLIENT_ETCD_ADDRESS"), "/")
+	if app.cfg.EtcdAddress != "" {
+		if !strings.HasPrefix(app.cfg.EtcdAddress, "http://") && !strings.HasPrefix(app.cfg.EtcdAddress, "https://") {
+			return app, errors.New("CW_CLIENT_ETCD_ADDRESS must start with http:// or https://")
+		}
+
+		etcdKV := &etcdKV{
+			address:  app.cfg.EtcdAddress,
+			username: os.Getenv("CW_CLIENT_ETCD_USERNAME"),
+		}
+
+		// CW_CLIENT_ETCD_PASSWORD
+		etcdKV.password, err = getEnvOrFile("CW_CLIENT_ETCD_PASSWORD")
+		if err != nil {
+			return app, err
+		}
+
+		// CW_CLIENT_ETCD_CA_FILE
+		etcdKV.httpClient, err = makeOutputBackendHttpClient(os.Getenv("CW_CLIENT_ETCD_CA_FILE"))
+		if err != nil {
+			return app, fmt.Errorf("invalid CW_CLIENT_ETCD_CA_FILE (%s)", err)
+		}
+
+		// CW_CLIENT_ETCD_PREFIX
+		app.cfg.EtcdPrefix = os.Getenv("CW_CLIENT_ETCD_PREFIX")
+		if app.cfg.EtcdPrefix == "" {
+			return app, errors.New("CW_CLIENT_ETCD_PREFIX is required when CW_CLIENT_ETCD_ADDRESS is specified")
+		}
+		etcdKV.prefix = app.cfg.EtcdPrefix
+
+		// CW_CLIENT_ETCD_FILES
+		etcdFiles := os.Getenv("CW_CLIENT_ETCD_FILES")
+		if etcdFiles == "" {
+			app.logger.Debugf("CW_CLIENT_ETCD_FILES not specified, using default \"%s\"", defaultEtcdFiles)
+			etcdFiles = defaultEtcdFiles
+		}
+		app.cfg.EtcdFiles = strings.Fields(etcdFiles)
+		for _, filename := range app.cfg.EtcdFiles {
+			if !app.isOutputFilename(filename) {
+				return app, fmt.Errorf("CW_CLIENT_ETCD_FILES contains %s which is not a configured output", filename)
+			}
+		}
+
+		app.outputBackends = append(app.outputBackends, &kvOutput{
+			storeName: "etcd",
+			store:     etcdKV,
+			prefix:    app.cfg.EtcdPrefix,
+			filenames: app.cfg.EtcdFiles,
+			makeContent: func(filename string, keyPem, certPem []byte) ([]byte, error) {
+				data, _, err := app.makeOutputContent(filename, keyPem, certPem)
+				return data, err
+			},
+			outputConfig: app.outputConfig(app.cfg.EtcdFiles),
+		})
+	}
+
+	// automatic rollback only reinstates files, another backend would keep the held cert
+	if app.cfg.DockerAutoRollback {
+		for _, backend := range app.outputBackends {
+			if _, isDockerCopy := backend.(*dockerCopyOutput); !isDockerCopy {
+				return app, fmt.Errorf("CW_CLIENT_RESTART_DOCKER_AUTO_ROLLBACK can't be used with the %s output, only files are rolled back", backend.name())
+			}
+		}
+	}
+
+	// CW_CLIENT_HAPROXY (0... etc.)
+	for i := 0; true; i++ {
+		prefix := "CW_CLIENT_HAPROXY" + strconv.Itoa(i)
+
+		socket := os.Getenv(prefix + "_SOCKET")
+		if socket == "" {
+			// if next number not specified, done
+			break
+		}
+
+		network, address, err := parseHAProxySocket(socket)
+		if err != nil {
+			return app, fmt.Errorf("invalid %s_SOCKET (%s)", prefix, err)
+		}
+
+		certPath := os.Getenv(prefix + "_CERT_PATH")
+		if certPath == "" {
+			return app, fmt.Errorf("%s_CERT_PATH is required", prefix)
+		}
+
+		fallbackContainer := os.Getenv(prefix + "_FALLBACK_CONTAINER")
+		if fallbackContainer != "" {
+			err = app.configDockerAPIClient(prefix + "_FALLBACK_CONTAINER")
+			if err != nil {
+				return app, err
+			}
+		}
+
+		app.postWriteActions = append(app.postWriteActions, &haproxyAction{
+			network:           network,
+			address:           address,
+			certPath:          certPath,
+			keyFormat:         app.cfg.KeyFormat,
+			fallbackContainer: fallbackContainer,
+			updateContainer:   app.updateDockerContainerAndWait,
+		})
+	}
+
+	// CW_CLIENT_SIGNAL (0... etc.)
+	for i := 0; true; i++ {
+		prefix := "CW_CLIENT_SIGNAL" + strconv.Itoa(i)
+
+		processSignal := &processSignalAction{
+			pidFile:     os.Getenv(prefix + "_PID_FILE"),
+			processName: os.Getenv(prefix + "_PROCESS_NAME"),
+		}
+		if processSignal.pidFile == "" && processSignal.processName == "" {
+			// if next number not specified, done
+			break
+		}
+		if processSignal.pidFile != "" && processSignal.processName != "" {
+			return app, fmt.Errorf("only one of %s_PID_FILE and %s_PROCESS_NAME can be specified", prefix, prefix)
+		}
+
+		signalStr := os.Getenv(prefix + "_SIGNAL")
+		if signalStr == "" {
+			app.logger.Debugf("%s_SIGNAL not specified, using default \"%s\"", prefix, defaultProcessSignal)
+			signalStr = defaultProcessSignal
+		}
+		processSignal.signal, err = parseSignal(signalStr)
+		if err != nil {
+			return app, fmt.Errorf("invalid %s_SIGNAL (%s)", prefix, err)
+		}
+
+		app.postWriteActions = append(app.postWriteActions, processSignal)
+	}
+
+	// CW_CLIENT_PRE_WRITE_HOOK (0... etc.)
+	app.preWriteHooks, err = app.configExecHooks("CW_CLIENT_PRE_WRITE_HOOK")
+	if err != nil {
+		return app, err
+	}
+
+	// CW_CLIENT_POST_WRITE_HOOK (0... etc.)
+	app.postWriteHooks, err = app.configExecHooks("CW_CLIENT_POST_WRITE_HOOK")
+	if err != nil {
+		return app, err
+	}
+
+	// CW_CLIENT_CADDY_ADMIN_ADDRESS
+	caddyAdminAddress := os.Getenv("CW_CLIENT_CADDY_ADMIN_ADDRESS")
+	if caddyAdminAddress != "" {
+		baseURL, httpClient, err := makeCaddyAdminClient(caddyAdminAddress)
+		if err != nil {
+			return app, fmt.Errorf("invalid CW_CLIENT_CADDY_ADMIN_ADDRESS (%s)", err)
+		}
+
+		// CW_CLIENT_CADDY_CONFIG_FILE
+		configFile := os.Getenv("CW_CLIENT_CADDY_CONFIG_FILE")
+		if configFile != "" {
+			_, err = os.Stat(configFile)
+			if err != nil {
+				return app, fmt.Errorf("invalid CW_CLIENT_CADDY_CONFIG_FILE (%s)", err)
+			}
+		}
+
+		// CW_CLIENT_CADDY_TAG
+		tag := os.Getenv("CW_CLIENT_CADDY_TAG")
+		if tag == "" {
+			app.logger.Debugf("CW_CLIENT_CADDY_TAG not specified, using default \"%s\"", defaultCaddyTag)
+			tag = defaultCaddyTag
+		}
+
+		app.postWriteActions = append(app.postWriteActions, &caddyAction{
+			baseURL:    baseURL,
+			httpClient: httpClient,
+			tag:        tag,
+			configFile: configFile,
+			keyFormat:  app.cfg.KeyFormat,
+		})
+	}
+
 	// end config vars
 
 	// make cert storage path (if not exist)
@@ -464,3 +1859,102 @@ func configureApp() (*app, error) {
 
 	return app, nil
 }
+
+// getEnvOrFile returns the value of the environment variable name or, if name_FILE is
+// set instead, the content of that file (e.g. a docker secret). It is an error for
+// both to be set.
+func getEnvOrFile(name string) (string, error) {
+	value := os.Getenv(name)
+	filename := os.Getenv(name + "_FILE")
+
+	if filename == "" {
+		return value, nil
+	}
+
+	if value != "" {
+		return "", fmt.Errorf("only one of %s and %s_FILE can be specified", name, name)
+	}
+
+	valueBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE (%s)", name, err)
+	}
+
+	return strings.TrimRight(string(valueBytes), "\r\n"), nil
+}
+
+// configExecHooks returns the hooks configured by the numbered vars that start with
+// prefix (e.g. CW_CLIENT_PRE_WRITE_HOOK0_COMMAND)
+func (app *app) configExecHooks(prefix string) ([]execHook, error) {
+	hooks := []execHook{}
+	for i := 0; true; i++ {
+		hookPrefix := prefix + strconv.Itoa(i)
+
+		hook := execHook{
+			command: os.Getenv(hookPrefix + "_COMMAND"),
+			dir:     os.Getenv(hookPrefix + "_DIR"),
+		}
+		if hook.command == "" {
+			// if next number not specified, done
+			break
+		}
+
+		timeoutStr := os.Getenv(hookPrefix + "_TIMEOUT")
+		timeoutSecs, err := strconv.Atoi(timeoutStr)
+		if timeoutStr == "" || err != nil || timeoutSecs <= 0 {
+			app.logger.Debugf("%s_TIMEOUT not specified or invalid, using default \"%d\"", hookPrefix, defaultExecHookTimeoutSeconds)
+			timeoutSecs = defaultExecHookTimeoutSeconds
+		}
+		hook.timeout = time.Duration(timeoutSecs) * time.Second
+
+		hookUser := os.Getenv(hookPrefix + "_USER")
+		if hookUser != "" {
+			hook.credential, err = parseExecHookUser(hookUser)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s_USER (%s)", hookPrefix, err)
+			}
+		}
+
+		for j := 0; true; j++ {
+			envVar := os.Getenv(hookPrefix + "_ENV" + strconv.Itoa(j))
+			if envVar == "" {
+				break
+			}
+			if !strings.Contains(envVar, "=") {
+				return nil, fmt.Errorf("%s_ENV%d must be in the form KEY=VALUE", hookPrefix, j)
+			}
+			hook.env = append(hook.env, envVar)
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// configDockerAPIClient makes the docker api client (if it hasn't been made already); the
+// one client is used by all of the docker features. specifiedBy is the config that needs
+// the client (for logging).
+func (app *app) configDockerAPIClient(specifiedBy string) error {
+	if app.dockerAPIClient != nil {
+		return nil
+	}
+
+	var err error
+	app.dockerAPIClient, err = dockerClient.NewClientWithOpts(
+		dockerClient.FromEnv,
+		dockerClient.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return fmt.Errorf("specified %s but couldn't make docker api client (%s)", specifiedBy, err)
+	}
+
+	testPingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelPing()
+	_, err = app.dockerAPIClient.Ping(testPingCtx)
+	if err != nil {
+		app.logger.Errorf("specified %s but couldn't connect to docker api (%s), verify access to docker or docker updates will not occur", specifiedBy, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// consulKV is a kvStore using the consul kv http api
+type consulKV struct {
+	address    string
+	token      string
+	httpClient *http.Client
+	// prefix that all keys are under (used to get all keys in one request)
+	prefix string
+}
+
+// do sends a request to the consul api and returns the response status code and body
+func (ck *consulKV) do(ctx context.Context, method, path string, reqBody []byte) (statusCode int, respBody []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, ck.address+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, nil, err
+	}
+	if ck.token != "" {
+		req.Header.Set("X-Consul-Token", ck.token)
+	}
+
+	resp, err := ck.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, respBody, nil
+}
+
+// get implements kvStore
+func (ck *consulKV) get(ctx context.Context, keys []string) (map[string]kvEntry, error) {
+	statusCode, body, err := ck.do(ctx, http.MethodGet, "/v1/kv/"+(&url.URL{Path: ck.prefix}).EscapedPath()+"?recurse=true", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]kvEntry{}
+
+	// nothing under prefix
+	if statusCode == http.StatusNotFound {
+		return entries, nil
+	} else if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading consul keys (status: %d)", statusCode)
+	}
+
+	var consulEntries []struct {
+		Key         string
+		Value       []byte
+		ModifyIndex int64
+	}
+	err = json.Unmarshal(body, &consulEntries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consul keys (%s)", err)
+	}
+
+	// only return requested keys
+	for _, key := range keys {
+		for _, consulEntry := range consulEntries {
+			if consulEntry.Key == key {
+				entries[key] = kvEntry{value: consulEntry.Value, revision: consulEntry.ModifyIndex}
+				break
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// putAll implements kvStore
+func (ck *consulKV) putAll(ctx context.Context, values map[string][]byte, revisions map[string]int64) error {
+	// check-and-set each key (index 0 means the key must not exist)
+	type consulTxnKV struct {
+		Verb  string
+		Key   string
+		Value []byte
+		Index int64
+	}
+	ops := []map[string]consulTxnKV{}
+	for key, value := range values {
+		ops = append(ops, map[string]consulTxnKV{
+			"KV": {
+				Verb:  "cas",
+				Key:   key,
+				Value: value,
+				Index: revisions[key],
+			},
+		})
+	}
+
+	txn, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+
+	statusCode, body, err := ck.do(ctx, http.MethodPut, "/v1/txn", txn)
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return nil
+
+	case http.StatusConflict:
+		// cas failed (or another op error)
+		return fmt.Errorf("%w (%s)", errKVConflict, bytes.TrimSpace(body))
+
+	default:
+		return fmt.Errorf("error writing consul keys (status: %d, %s)", statusCode, bytes.TrimSpace(body))
+	}
+}
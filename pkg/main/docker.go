@@ -1,43 +1,439 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	dockerTypes "github.com/docker/docker/api/types"
 	dockerContainerTypes "github.com/docker/docker/api/types/container"
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
 const dockerRestartContextTimeout = 3 * time.Minute
 const dockerGracefulExitTimeoutSeconds = 60
 
-// restartOrStopDockerContainers stops or restarts each of the container names specified in the
-// config file; this func is called after cert files are updated; restarts/stops are done
-// async and results are logged
-func (app *app) restartOrStopDockerContainers() {
-	for _, container := range app.cfg.DockerContainersToRestart {
-		go func(asyncContainer string) {
-			restartCtx, cancel := context.WithTimeout(context.Background(), dockerRestartContextTimeout)
-			defer cancel()
-
-			// restart (or stop if configured)
-			timeoutSecs := dockerGracefulExitTimeoutSeconds
-			if app.cfg.DockerStopOnly {
-				err := app.dockerAPIClient.ContainerStop(restartCtx, asyncContainer, dockerContainerTypes.StopOptions{Timeout: &timeoutSecs})
-				if err != nil {
-					app.logger.Errorf("failed to stop container %s (%s)", asyncContainer, err)
-				} else {
-					app.logger.Infof("successfully stopped container: %s", asyncContainer)
-				}
+// errDockerContainerUnhealthy is returned when a container was updated but did not
+// become healthy
+var errDockerContainerUnhealthy = errors.New("container did not become healthy")
+
+// docker container actions
+const (
+	dockerActionRestart = "restart"
+	dockerActionStop    = "stop"
+	dockerActionSignal  = "signal"
+	dockerActionExec    = "exec"
+)
+
+// labels on containers selected by label filter that set how the container is updated
+const (
+	dockerLabelAction = "certwarden.action"
+	dockerLabelSignal = "certwarden.signal"
+	dockerLabelExec   = "certwarden.exec"
+	dockerLabelOrder  = "certwarden.order"
+)
+
+// dockerContainer is a container that is updated after cert files are updated and the
+// action to update it with
+type dockerContainer struct {
+	Name string
+	// Action is one of the docker container actions (blank is restart, or stop if
+	// CW_CLIENT_RESTART_DOCKER_STOP_ONLY)
+	Action string
+	// Signal is the signal name for the signal action (e.g. SIGHUP)
+	Signal string
+	// Exec is the command (and args) for the exec action
+	Exec []string
+	// Order is the group the container is updated in; lower groups are updated first
+	Order int
+}
+
+// parseDockerAction validates a docker container action string
+func parseDockerAction(action string) (string, error) {
+	action = strings.ToLower(action)
+	switch action {
+	case "", dockerActionRestart, dockerActionStop, dockerActionSignal, dockerActionExec:
+		return action, nil
+	default:
+		return "", fmt.Errorf("invalid docker action \"%s\" (must be %s, %s, %s, or %s)", action, dockerActionRestart, dockerActionStop, dockerActionSignal, dockerActionExec)
+	}
+}
+
+// makeDockerContainer returns a container with the specified update options, validating
+// them (blank signal is the default signal)
+func makeDockerContainer(name, action, signal, exec, order string) (dockerContainer, error) {
+	container := dockerContainer{
+		Name: name,
+	}
+
+	var err error
+	container.Action, err = parseDockerAction(action)
+	if err != nil {
+		return dockerContainer{}, err
+	}
+
+	switch container.Action {
+	case dockerActionSignal:
+		if signal == "" {
+			signal = defaultDockerSignal
+		}
+		sig, err := parseSignal(signal)
+		if err != nil {
+			return dockerContainer{}, err
+		}
+		container.Signal = signalName(sig)
+
+	case dockerActionExec:
+		container.Exec = strings.Fields(exec)
+		if len(container.Exec) == 0 {
+			return dockerContainer{}, errors.New("exec command is required for the exec action")
+		}
+	}
+
+	if order != "" {
+		container.Order, err = strconv.Atoi(order)
+		if err != nil {
+			return dockerContainer{}, fmt.Errorf("invalid order \"%s\" (must be an integer)", order)
+		}
+	}
+
+	return container, nil
+}
+
+// dockerUpdatesConfigured returns true if any containers (by name or label) are
+// configured to be updated after cert files are updated
+func (app *app) dockerUpdatesConfigured() bool {
+	return len(app.cfg.DockerContainersToRestart) > 0 || len(app.cfg.DockerLabelFilters) > 0
+}
+
+// dockerContainersToUpdate returns the containers specified by name in the config and the
+// running containers that match any of the label filters (using the containers' labels for
+// their update options), sorted by order
+func (app *app) dockerContainersToUpdate(ctx context.Context) []dockerContainer {
+	containers := slices.Clone(app.cfg.DockerContainersToRestart)
+
+	names := map[string]struct{}{}
+	for _, container := range containers {
+		names[container.Name] = struct{}{}
+	}
+
+	for _, labelFilter := range app.cfg.DockerLabelFilters {
+		list, err := app.dockerAPIClient.ContainerList(ctx, dockerContainerTypes.ListOptions{
+			Filters: dockerFilters.NewArgs(dockerFilters.Arg("label", labelFilter)),
+		})
+		if err != nil {
+			app.logger.Errorf("failed to list docker containers with label %s (%s)", labelFilter, err)
+			continue
+		}
 
-			} else {
-				err := app.dockerAPIClient.ContainerRestart(restartCtx, asyncContainer, dockerContainerTypes.StopOptions{Timeout: &timeoutSecs})
-				if err != nil {
-					app.logger.Errorf("failed to restart container %s (%s)", asyncContainer, err)
+		if len(list) == 0 {
+			app.logger.Warnf("no running docker containers have label %s", labelFilter)
+		}
+
+		for _, listed := range list {
+			name := listed.ID
+			if len(listed.Names) > 0 {
+				name = strings.TrimPrefix(listed.Names[0], "/")
+			}
+
+			// already selected by name or another label
+			if _, exists := names[name]; exists {
+				continue
+			}
+			names[name] = struct{}{}
+
+			container, err := makeDockerContainer(name, listed.Labels[dockerLabelAction], listed.Labels[dockerLabelSignal],
+				listed.Labels[dockerLabelExec], listed.Labels[dockerLabelOrder])
+			if err != nil {
+				app.logger.Errorf("not updating docker container %s, its certwarden labels are invalid (%s)", name, err)
+				continue
+			}
+
+			containers = append(containers, container)
+		}
+	}
+
+	slices.SortStableFunc(containers, func(a, b dockerContainer) int {
+		return a.Order - b.Order
+	})
+
+	return containers
+}
+
+// dockerContainerAction returns the action to do for the container
+func (app *app) dockerContainerAction(container dockerContainer) string {
+	if container.Action != "" {
+		return container.Action
+	}
+
+	if app.cfg.DockerStopOnly {
+		return dockerActionStop
+	}
+	return dockerActionRestart
+}
+
+// dockerExec runs the command in the container, waits for it to finish, and returns an
+// error if it didn't exit 0
+func (app *app) dockerExec(ctx context.Context, containerName string, cmd []string) error {
+	execCreate, err := app.dockerAPIClient.ContainerExecCreate(ctx, containerName, dockerContainerTypes.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// attach starts the exec, the output ends when it exits
+	attach, err := app.dockerAPIClient.ContainerExecAttach(ctx, execCreate.ID, dockerContainerTypes.ExecAttachOptions{})
+	if err != nil {
+		return err
+	}
+	defer attach.Close()
+
+	output := new(bytes.Buffer)
+	_, err = stdcopy.StdCopy(output, output, attach.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to read exec output (%s)", err)
+	}
+
+	outputStr := strings.TrimSpace(output.String())
+	if outputStr != "" {
+		app.logger.Debugf("exec `%s` in container %s output: %s", strings.Join(cmd, " "), containerName, outputStr)
+	}
+
+	// exit code (exec may not be marked done the instant output ends)
+	for {
+		inspect, err := app.dockerAPIClient.ContainerExecInspect(ctx, execCreate.ID)
+		if err != nil {
+			return err
+		}
+
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return fmt.Errorf("exited with code %d", inspect.ExitCode)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// updateDockerContainer does the configured action for the container and logs the result
+func (app *app) updateDockerContainer(ctx context.Context, container dockerContainer) error {
+	timeoutSecs := dockerGracefulExitTimeoutSeconds
+
+	// failed and done describe the action for logging
+	var err error
+	var failed, done string
+	switch app.dockerContainerAction(container) {
+	case dockerActionStop:
+		err = app.dockerAPIClient.ContainerStop(ctx, container.Name, dockerContainerTypes.StopOptions{Timeout: &timeoutSecs})
+		failed, done = "stop container", "stopped container"
+
+	case dockerActionSignal:
+		err = app.dockerAPIClient.ContainerKill(ctx, container.Name, container.Signal)
+		failed, done = fmt.Sprintf("send %s to container", container.Signal), fmt.Sprintf("sent %s to container", container.Signal)
+
+	case dockerActionExec:
+		err = app.dockerExec(ctx, container.Name, container.Exec)
+		failed, done = fmt.Sprintf("run `%s` in container", strings.Join(container.Exec, " ")), fmt.Sprintf("ran `%s` in container", strings.Join(container.Exec, " "))
+
+	default:
+		err = app.dockerAPIClient.ContainerRestart(ctx, container.Name, dockerContainerTypes.StopOptions{Timeout: &timeoutSecs})
+		failed, done = "restart container", "restarted container"
+	}
+
+	if err != nil {
+		app.logger.Errorf("failed to %s %s (%s)", failed, container.Name, err)
+		return err
+	}
+
+	app.logger.Infof("successfully %s: %s", done, container.Name)
+	return nil
+}
+
+// waitDockerContainerHealthy waits for the container to report healthy or, if it has no
+// health check, to be running (without restarting) for the configured number of seconds
+// since it started or since actionTime (whichever is later). It returns an error if the
+// container is unhealthy, stops, or ctx is done first.
+func (app *app) waitDockerContainerHealthy(ctx context.Context, containerName string, actionTime time.Time) error {
+	runningFor := time.Duration(app.cfg.DockerRunningSeconds) * time.Second
+
+	for {
+		inspect, err := app.dockerAPIClient.ContainerInspect(ctx, containerName)
+		if err != nil {
+			return fmt.Errorf("failed to inspect container (%s)", err)
+		}
+		if inspect.ContainerJSONBase == nil || inspect.State == nil {
+			return errors.New("container inspect did not include state")
+		}
+		state := inspect.State
+
+		hasHealthCheck := state.Health != nil && state.Health.Status != dockerTypes.NoHealthcheck
+		switch {
+		case hasHealthCheck && state.Health.Status == dockerTypes.Healthy:
+			return nil
+
+		case hasHealthCheck && state.Health.Status == dockerTypes.Unhealthy:
+			return errors.New("container is unhealthy")
+
+		case !state.Running && !state.Restarting:
+			return fmt.Errorf("container is not running (status %s, exit code %d)", state.Status, state.ExitCode)
+
+		case !hasHealthCheck && state.Running && !state.Restarting:
+			// a crash looping container keeps starting over, so never runs long enough
+			startedAt, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+			if err != nil || startedAt.Before(actionTime) {
+				startedAt = actionTime
+			}
+			if time.Since(startedAt) >= runningFor {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if hasHealthCheck {
+				return fmt.Errorf("timed out waiting for container to be healthy (status %s)", state.Health.Status)
+			}
+			return fmt.Errorf("timed out waiting for container to run for %s", runningFor)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// updateDockerContainerAndWait updates the container and then, if configured, waits for
+// it to become healthy (stopped containers are not waited for)
+func (app *app) updateDockerContainerAndWait(container dockerContainer) error {
+	actionTime := time.Now()
+
+	restartCtx, cancel := context.WithTimeout(context.Background(), dockerRestartContextTimeout)
+	defer cancel()
+
+	err := app.updateDockerContainer(restartCtx, container)
+	if err != nil {
+		return err
+	}
+
+	if !app.cfg.DockerWaitHealthy || app.dockerContainerAction(container) == dockerActionStop {
+		return nil
+	}
+
+	healthCtx, cancelHealth := context.WithTimeout(context.Background(), time.Duration(app.cfg.DockerHealthTimeoutSeconds)*time.Second)
+	defer cancelHealth()
+
+	app.logger.Debugf("waiting for container %s to be healthy", container.Name)
+	err = app.waitDockerContainerHealthy(healthCtx, container.Name, actionTime)
+	if err != nil {
+		app.logger.Errorf("container %s did not become healthy (%s)", container.Name, err)
+		return fmt.Errorf("%w (%s)", errDockerContainerUnhealthy, err)
+	}
+
+	app.logger.Infof("container %s is healthy", container.Name)
+	return nil
+}
+
+// restartOrStopDockerContainers does the configured action (restart, stop, signal, or
+// exec) for each of the containers specified in the config or selected by label; this
+// func is called after cert files are updated. Containers are updated in order groups
+// (lowest first) with up to the configured max concurrent updates at a time, waiting for
+// each to be healthy if configured. A container that fails to update is logged and the
+// rest are still updated, but if a container doesn't become healthy, no more updates are
+// started and an error is returned. The outcome is logged.
+func (app *app) restartOrStopDockerContainers() error {
+	listCtx, cancelList := context.WithTimeout(context.Background(), dockerRestartContextTimeout)
+	defer cancelList()
+	containers := app.dockerContainersToUpdate(listCtx)
+
+	if len(containers) == 0 {
+		app.logger.Warn("no docker containers to update")
+		return nil
+	}
+
+	// results
+	mu := new(sync.Mutex)
+	updated, failed, unhealthy, skipped := []string{}, []string{}, []string{}, []string{}
+
+	for len(containers) > 0 {
+		// containers are sorted, so the group is all with the first's order
+		groupLen := 1
+		for groupLen < len(containers) && containers[groupLen].Order == containers[0].Order {
+			groupLen++
+		}
+		group := containers[:groupLen]
+		containers = containers[groupLen:]
+
+		maxConcurrent := app.cfg.DockerMaxConcurrent
+		if maxConcurrent <= 0 {
+			maxConcurrent = len(group)
+		}
+		slots := make(chan struct{}, maxConcurrent)
+
+		wg := new(sync.WaitGroup)
+		for _, container := range group {
+			// wait for a free slot
+			slots <- struct{}{}
+
+			mu.Lock()
+			if len(unhealthy) > 0 {
+				// rollout aborted
+				skipped = append(skipped, container.Name)
+				mu.Unlock()
+				<-slots
+				continue
+			}
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(asyncContainer dockerContainer) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+
+				err := app.updateDockerContainerAndWait(asyncContainer)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if errors.Is(err, errDockerContainerUnhealthy) {
+					unhealthy = append(unhealthy, asyncContainer.Name)
+				} else if err != nil {
+					failed = append(failed, asyncContainer.Name)
 				} else {
-					app.logger.Infof("successfully restarted container: %s", asyncContainer)
+					updated = append(updated, asyncContainer.Name)
 				}
-			}
+			}(container)
+		}
+
+		wg.Wait()
+	}
 
-		}(container)
+	// log summary
+	if len(unhealthy) > 0 {
+		app.logger.Errorf("docker container update aborted: %d updated, %d unhealthy (%s), %d failed (%s), %d not updated (%s)", len(updated),
+			len(unhealthy), strings.Join(unhealthy, ", "), len(failed), strings.Join(failed, ", "), len(skipped), strings.Join(skipped, ", "))
+		return fmt.Errorf("docker container(s) %s did not become healthy", strings.Join(unhealthy, ", "))
 	}
+
+	if len(failed) > 0 {
+		app.logger.Errorf("docker container update complete: %d updated (%s), %d failed (%s)", len(updated), strings.Join(updated, ", "),
+			len(failed), strings.Join(failed, ", "))
+		return nil
+	}
+
+	app.logger.Infof("docker container update complete: %d updated (%s)", len(updated), strings.Join(updated, ", "))
+	return nil
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	dockerContainerTypes "github.com/docker/docker/api/types/container"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// dockerCopySourceFilename is a file copied into the container dir (with the other files)
+// that contains the hash of the key/cert the files were made from; it is used to
+// determine if a container is current
+const dockerCopySourceFilename = ".certwarden-source"
+
+// dockerCopyOutput is an output backend that copies the configured outputs into a dir in
+// each of the docker containers that are updated (selected by name or label), for
+// containers that can't share a volume with the client. Ownership and mode are set by
+// the tar headers of the copy.
+type dockerCopyOutput struct {
+	client *dockerClient.Client
+	// containers returns the containers to copy to (see app.dockerContainersToUpdate)
+	containers func(ctx context.Context) []dockerContainer
+	dir        string
+	uid        int
+	gid        int
+	// filenames of the outputs to copy (see app.makeOutputContent)
+	filenames   []string
+	makeContent func(filename string, keyPem, certPem []byte) ([]byte, fs.FileMode, error)
+	// outputConfig is the config of the outputs (see app.outputConfig)
+	outputConfig string
+}
+
+// name implements outputBackend
+func (dco *dockerCopyOutput) name() string {
+	return fmt.Sprintf("docker container copy to %s", dco.dir)
+}
+
+// readSource returns the content of the source file in the container
+func (dco *dockerCopyOutput) readSource(ctx context.Context, containerName string) ([]byte, error) {
+	reader, _, err := dco.client.CopyFromContainer(ctx, containerName, path.Join(dco.dir, dockerCopySourceFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	_, err = tr.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(io.LimitReader(tr, 1024))
+}
+
+// status implements outputBackend
+func (dco *dockerCopyOutput) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	containers := dco.containers(ctx)
+
+	exists = true
+	current = true
+	for _, container := range containers {
+		// all files exist?
+		for _, filename := range dco.filenames {
+			_, err = dco.client.ContainerStatPath(ctx, container.Name, path.Join(dco.dir, filename))
+			if errdefs.IsNotFound(err) {
+				return false, false, nil
+			} else if err != nil {
+				return false, false, fmt.Errorf("failed to stat %s in container %s (%s)", filename, container.Name, err)
+			}
+		}
+
+		// current?
+		source, err := dco.readSource(ctx, container.Name)
+		if errdefs.IsNotFound(err) {
+			current = false
+			continue
+		} else if err != nil {
+			return false, false, fmt.Errorf("failed to read %s in container %s (%s)", dockerCopySourceFilename, container.Name, err)
+		}
+
+		if string(bytes.TrimSpace(source)) != outputSourceHash(keyPem, certPem, dco.outputConfig) {
+			current = false
+		}
+	}
+
+	return exists, current, nil
+}
+
+// makeTar returns a tar of the files (and the source file) to extract at the container's
+// root
+func (dco *dockerCopyOutput) makeTar(files []fileWrite, source string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	// source is last so it only exists if the files were all copied
+	files = append(files, fileWrite{filename: dockerCopySourceFilename, data: []byte(source + "\n"), perm: 0600})
+
+	modTime := time.Now()
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			// relative to the root, missing parent dirs are made by docker
+			Name:    strings.TrimPrefix(path.Join(dco.dir, file.filename), "/"),
+			Mode:    int64(file.perm.Perm()),
+			Uid:     dco.uid,
+			Gid:     dco.gid,
+			Size:    int64(len(file.data)),
+			ModTime: modTime,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tw.Write(file.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// copyFiles copies the files into each of the containers
+func (dco *dockerCopyOutput) copyFiles(ctx context.Context, files []fileWrite, source string) error {
+	tarBytes, err := dco.makeTar(files, source)
+	if err != nil {
+		return fmt.Errorf("failed to make tar (%s)", err)
+	}
+
+	containers := dco.containers(ctx)
+	if len(containers) == 0 {
+		return errors.New("no docker containers to copy to")
+	}
+
+	failures := []string{}
+	for _, container := range containers {
+		err = dco.client.CopyToContainer(ctx, container.Name, "/", bytes.NewReader(tarBytes), dockerContainerTypes.CopyToContainerOptions{})
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("failed to copy to container %s (%s)", container.Name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// write implements outputBackend
+func (dco *dockerCopyOutput) write(ctx context.Context, keyPem, certPem []byte) error {
+	files := []fileWrite{}
+	for _, filename := range dco.filenames {
+		data, perm, err := dco.makeContent(filename, keyPem, certPem)
+		if err != nil {
+			return fmt.Errorf("failed to make %s (%s)", filename, err)
+		}
+		files = append(files, fileWrite{filename: filename, data: data, perm: perm})
+	}
+
+	return dco.copyFiles(ctx, files, outputSourceHash(keyPem, certPem, dco.outputConfig))
+}
+
+// copyArchivedFiles copies the configured files from an archived version's files into
+// each of the containers (used by rollback)
+func (dco *dockerCopyOutput) copyArchivedFiles(ctx context.Context, archivedFiles []fileWrite) error {
+	files := []fileWrite{}
+	var keyPem, certPem []byte
+	for _, filename := range dco.filenames {
+		found := false
+		for _, archivedFile := range archivedFiles {
+			if archivedFile.filename == filename {
+				files = append(files, archivedFile)
+				found = true
+			}
+
+			switch archivedFile.filename {
+			case "key.pem":
+				keyPem = archivedFile.data
+			case "certchain.pem":
+				certPem = archivedFile.data
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%s is not in the archived version", filename)
+		}
+	}
+
+	return dco.copyFiles(ctx, files, outputSourceHash(keyPem, certPem, dco.outputConfig))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dockerClient "github.com/docker/docker/client"
+)
+
+// fakeDockerContainer is a container of the fake docker api
+type fakeDockerContainer struct {
+	labels map[string]string
+	// health is the container's health check status (blank is no health check)
+	health string
+	// healthAfterUpdates is the health after each of the next updates
+	healthAfterUpdates []string
+}
+
+// fakeDockerAPI is an in memory docker api with enough of the container endpoints to
+// update containers and wait for them to be healthy
+type fakeDockerAPI struct {
+	mu         sync.Mutex
+	containers map[string]*fakeDockerContainer
+	// actions done to containers, e.g. "restart nginx"
+	actions []string
+}
+
+// fakeDockerPath matches an api path (after the version prefix is removed)
+var fakeDockerPath = regexp.MustCompile(`^(?:/v[0-9.]+)?(/.*)$`)
+
+func (fd *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	w.Header().Set("Api-Version", "1.47")
+	path := fakeDockerPath.FindStringSubmatch(r.URL.Path)[1]
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "No such container"})
+	}
+
+	switch {
+	case path == "/_ping":
+		_, _ = w.Write([]byte("OK"))
+
+	case path == "/containers/json" && r.Method == http.MethodGet:
+		filters := map[string]map[string]bool{}
+		_ = json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+
+		list := []map[string]any{}
+		for name, container := range fd.containers {
+			matches := true
+			for labelFilter := range filters["label"] {
+				k, v, hasValue := strings.Cut(labelFilter, "=")
+				labelValue, hasLabel := container.labels[k]
+				if !hasLabel || (hasValue && labelValue != v) {
+					matches = false
+				}
+			}
+			if matches {
+				list = append(list, map[string]any{"Id": name, "Names": []string{"/" + name}, "Labels": container.labels})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+
+	case len(parts) == 3 && parts[0] == "containers" && r.Method == http.MethodPost:
+		container := fd.containers[parts[1]]
+		if container == nil {
+			notFound()
+			return
+		}
+		fd.actions = append(fd.actions, parts[2]+" "+parts[1])
+		if len(container.healthAfterUpdates) > 0 {
+			container.health = container.healthAfterUpdates[0]
+			container.healthAfterUpdates = container.healthAfterUpdates[1:]
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "json" && r.Method == http.MethodGet:
+		container := fd.containers[parts[1]]
+		if container == nil {
+			notFound()
+			return
+		}
+		state := map[string]any{"Running": true, "Status": "running", "StartedAt": time.Now().Add(-time.Hour).Format(time.RFC3339Nano)}
+		if container.health != "" {
+			state["Health"] = map[string]any{"Status": container.health}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"Id": parts[1], "Name": "/" + parts[1], "State": state})
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// actionsString returns the actions done so far, separated by commas
+func (fd *fakeDockerAPI) actionsString() string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	return strings.Join(fd.actions, ", ")
+}
+
+// testFakeDocker starts a fake docker api with the named containers and sets it as
+// the app's docker api client
+func testFakeDocker(t *testing.T, app *app, containerNames ...string) *fakeDockerAPI {
+	t.Helper()
+
+	fake := &fakeDockerAPI{containers: map[string]*fakeDockerContainer{}}
+	for _, name := range containerNames {
+		fake.containers[name] = &fakeDockerContainer{}
+	}
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	var err error
+	app.dockerAPIClient, err = dockerClient.NewClientWithOpts(
+		dockerClient.WithHost("tcp://"+srv.Listener.Addr().String()),
+		dockerClient.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake
+}
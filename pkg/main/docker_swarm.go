@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerFilters "github.com/docker/docker/api/types/filters"
+	dockerSwarm "github.com/docker/docker/api/types/swarm"
+	dockerClient "github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// dockerSwarmLabelName is the label on the swarm secrets and configs the client creates
+// that holds the item's name
+const dockerSwarmLabelName = "certwarden.name"
+
+// docker swarm object names are limited to 64 characters; a versioned name is the item's
+// name followed by -<cert serial>-<source hash>, where the serial is up to 40 hex
+// characters (20 octets)
+const (
+	dockerSwarmMaxNameLen       = 64
+	dockerSwarmSourceHashLen    = 8
+	dockerSwarmVersionSuffixLen = 1 + 40 + 1 + dockerSwarmSourceHashLen
+)
+
+// dockerSwarmItem is an output that is stored as a versioned swarm secret (or config)
+// named <name>-<cert serial>-<source hash>
+type dockerSwarmItem struct {
+	name     string
+	filename string
+	// config is true if the item is a swarm config instead of a secret
+	config bool
+}
+
+// kind describes the item's type (for logging)
+func (item *dockerSwarmItem) kind() string {
+	if item.config {
+		return "config"
+	}
+	return "secret"
+}
+
+// versionedName returns the item's name for the cert and source hash (see
+// outputSourceHash); a new version is made if either changes
+func (item *dockerSwarmItem) versionedName(certPem []byte, source string) (string, error) {
+	cert, _, err := certPemToCerts(certPem)
+	if err != nil {
+		return "", err
+	}
+
+	return item.name + "-" + cert.SerialNumber.Text(16) + "-" + source[:dockerSwarmSourceHashLen], nil
+}
+
+// dockerSwarmObject is the subset of a swarm secret or config that the client uses
+type dockerSwarmObject struct {
+	id        string
+	name      string
+	labels    map[string]string
+	createdAt time.Time
+}
+
+// dockerSwarmOutput is an output backend that rotates swarm secrets (and configs), which
+// are immutable: a new versioned secret is created for each item, the configured services
+// are updated to use it in place of the previous version (keeping the same target), and
+// old versions are pruned (the previous version is kept so a service update can roll
+// back)
+type dockerSwarmOutput struct {
+	client   *dockerClient.Client
+	items    []dockerSwarmItem
+	services []string
+	// makeContent makes the item's content (see app.makeOutputContent)
+	makeContent func(filename string, keyPem, certPem []byte) ([]byte, fs.FileMode, error)
+	// outputConfig is the config of the outputs (see app.outputConfig)
+	outputConfig string
+	logger       *zap.SugaredLogger
+}
+
+// name implements outputBackend
+func (dso *dockerSwarmOutput) name() string {
+	return fmt.Sprintf("docker swarm secrets for service(s) %v", dso.services)
+}
+
+// listObjects returns all of the versions of the item that the client created
+func (dso *dockerSwarmOutput) listObjects(ctx context.Context, item dockerSwarmItem) ([]dockerSwarmObject, error) {
+	filters := dockerFilters.NewArgs(dockerFilters.Arg("label", dockerSwarmLabelName+"="+item.name))
+
+	objects := []dockerSwarmObject{}
+	if item.config {
+		configs, err := dso.client.ConfigList(ctx, dockerTypes.ConfigListOptions{Filters: filters})
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			objects = append(objects, dockerSwarmObject{id: config.ID, name: config.Spec.Name, labels: config.Spec.Labels, createdAt: config.CreatedAt})
+		}
+	} else {
+		secrets, err := dso.client.SecretList(ctx, dockerTypes.SecretListOptions{Filters: filters})
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets {
+			objects = append(objects, dockerSwarmObject{id: secret.ID, name: secret.Spec.Name, labels: secret.Spec.Labels, createdAt: secret.CreatedAt})
+		}
+	}
+
+	return objects, nil
+}
+
+// createObject creates a version of the item and returns its id
+func (dso *dockerSwarmOutput) createObject(ctx context.Context, item dockerSwarmItem, name string, data []byte) (string, error) {
+	annotations := dockerSwarm.Annotations{
+		Name: name,
+		Labels: map[string]string{
+			dockerSwarmLabelName: item.name,
+		},
+	}
+
+	if item.config {
+		resp, err := dso.client.ConfigCreate(ctx, dockerSwarm.ConfigSpec{Annotations: annotations, Data: data})
+		return resp.ID, err
+	}
+
+	resp, err := dso.client.SecretCreate(ctx, dockerSwarm.SecretSpec{Annotations: annotations, Data: data})
+	return resp.ID, err
+}
+
+// removeObject removes a version of the item
+func (dso *dockerSwarmOutput) removeObject(ctx context.Context, item dockerSwarmItem, id string) error {
+	if item.config {
+		return dso.client.ConfigRemove(ctx, id)
+	}
+	return dso.client.SecretRemove(ctx, id)
+}
+
+// findObject returns the object named name (or nil)
+func findObject(objects []dockerSwarmObject, name string) *dockerSwarmObject {
+	for i := range objects {
+		if objects[i].name == name {
+			return &objects[i]
+		}
+	}
+	return nil
+}
+
+// serviceUses returns true if the service's spec references the named secret (or
+// config) for the item
+func serviceUses(spec dockerSwarm.ServiceSpec, item dockerSwarmItem, name string) bool {
+	containerSpec := spec.TaskTemplate.ContainerSpec
+	if containerSpec == nil {
+		return false
+	}
+
+	if item.config {
+		for _, ref := range containerSpec.Configs {
+			if ref.ConfigName == name {
+				return true
+			}
+		}
+	} else {
+		for _, ref := range containerSpec.Secrets {
+			if ref.SecretName == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// replaceServiceRefs changes the service spec's references to any version of the item
+// (or the unversioned item name) to the new version, keeping each reference's target. It
+// returns false if the spec doesn't reference the item.
+func replaceServiceRefs(spec *dockerSwarm.ServiceSpec, item dockerSwarmItem, versions []dockerSwarmObject, newID, newName string) bool {
+	containerSpec := spec.TaskTemplate.ContainerSpec
+	if containerSpec == nil {
+		return false
+	}
+
+	isItem := func(id, name string) bool {
+		if name == item.name {
+			return true
+		}
+		for _, version := range versions {
+			if version.id == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	replaced := false
+	if item.config {
+		for _, ref := range containerSpec.Configs {
+			if isItem(ref.ConfigID, ref.ConfigName) {
+				ref.ConfigID, ref.ConfigName = newID, newName
+				replaced = true
+			}
+		}
+	} else {
+		for _, ref := range containerSpec.Secrets {
+			if isItem(ref.SecretID, ref.SecretName) {
+				ref.SecretID, ref.SecretName = newID, newName
+				replaced = true
+			}
+		}
+	}
+
+	return replaced
+}
+
+// status implements outputBackend
+func (dso *dockerSwarmOutput) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	source := outputSourceHash(keyPem, certPem, dso.outputConfig)
+
+	// versions exist and are current?
+	names := make([]string, len(dso.items))
+	current = true
+	for i, item := range dso.items {
+		names[i], err = item.versionedName(certPem, source)
+		if err != nil {
+			return false, false, err
+		}
+
+		versions, err := dso.listObjects(ctx, item)
+		if err != nil {
+			return false, false, fmt.Errorf("failed to list %ss (%s)", item.kind(), err)
+		}
+
+		// never rotated
+		if len(versions) == 0 {
+			return false, false, nil
+		}
+
+		if findObject(versions, names[i]) == nil {
+			current = false
+		}
+	}
+
+	// services use them?
+	for _, service := range dso.services {
+		svc, _, err := dso.client.ServiceInspectWithRaw(ctx, service, dockerTypes.ServiceInspectOptions{})
+		if err != nil {
+			return false, false, fmt.Errorf("failed to inspect service %s (%s)", service, err)
+		}
+
+		for i, item := range dso.items {
+			if !serviceUses(svc.Spec, item, names[i]) {
+				current = false
+			}
+		}
+	}
+
+	return true, current, nil
+}
+
+// write implements outputBackend
+func (dso *dockerSwarmOutput) write(ctx context.Context, keyPem, certPem []byte) error {
+	source := outputSourceHash(keyPem, certPem, dso.outputConfig)
+
+	// create the new versions
+	names := make([]string, len(dso.items))
+	ids := make([]string, len(dso.items))
+	allVersions := make([][]dockerSwarmObject, len(dso.items))
+	for i, item := range dso.items {
+		data, _, err := dso.makeContent(item.filename, keyPem, certPem)
+		if err != nil {
+			return fmt.Errorf("failed to make %s (%s)", item.filename, err)
+		}
+
+		names[i], err = item.versionedName(certPem, source)
+		if err != nil {
+			return err
+		}
+
+		allVersions[i], err = dso.listObjects(ctx, item)
+		if err != nil {
+			return fmt.Errorf("failed to list %ss (%s)", item.kind(), err)
+		}
+
+		// already created (e.g. a previous write failed to update a service)
+		existing := findObject(allVersions[i], names[i])
+		if existing != nil {
+			ids[i] = existing.id
+			continue
+		}
+
+		ids[i], err = dso.createObject(ctx, item, names[i], data)
+		if err != nil {
+			return fmt.Errorf("failed to create %s %s (%s)", item.kind(), names[i], err)
+		}
+	}
+
+	// update the services to use them
+	for _, service := range dso.services {
+		svc, _, err := dso.client.ServiceInspectWithRaw(ctx, service, dockerTypes.ServiceInspectOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to inspect service %s (%s)", service, err)
+		}
+
+		spec := svc.Spec
+		for i, item := range dso.items {
+			if !replaceServiceRefs(&spec, item, allVersions[i], ids[i], names[i]) {
+				// the target (path, owner, and mode) comes from the existing reference
+				return fmt.Errorf("service %s does not use %s %s (add it to the service first)", service, item.kind(), item.name)
+			}
+		}
+
+		_, err = dso.client.ServiceUpdate(ctx, svc.ID, svc.Version, spec, dockerTypes.ServiceUpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update service %s (%s)", service, err)
+		}
+	}
+
+	// prune old versions, keeping the newest previous version (a failure isn't retried
+	// since the services are current, old versions are pruned on the next rotation)
+	for i, item := range dso.items {
+		old := []dockerSwarmObject{}
+		for _, version := range allVersions[i] {
+			if version.name != names[i] {
+				old = append(old, version)
+			}
+		}
+		sort.Slice(old, func(a, b int) bool { return old[a].createdAt.After(old[b].createdAt) })
+
+		for j := 1; j < len(old); j++ {
+			// a version still used by a service that isn't configured can't be removed
+			err := dso.removeObject(ctx, item, old[j].id)
+			if err != nil {
+				dso.logger.Warnf("%s: failed to remove old %s %s (%s)", dso.name(), item.kind(), old[j].name, err)
+			}
+		}
+	}
+
+	return nil
+}
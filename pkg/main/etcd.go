@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// etcdKV is a kvStore using the etcd v3 json (grpc gateway) api
+type etcdKV struct {
+	address    string
+	username   string
+	password   string
+	httpClient *http.Client
+	// prefix that all keys are under (used to get all keys in one request)
+	prefix string
+}
+
+// etcdPrefixEnd returns the range end that includes every key starting with prefix
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	// all 0xff, range to end of keyspace
+	return []byte{0}
+}
+
+// do sends a json request to the etcd api and decodes the json response into
+// respPayload. If a username is configured, it authenticates first.
+func (ek *etcdKV) do(ctx context.Context, path string, reqPayload any, respPayload any) error {
+	token := ""
+	if ek.username != "" {
+		var authResp struct {
+			Token string `json:"token"`
+		}
+		err := ek.doWithToken(ctx, "", "/v3/auth/authenticate", map[string]string{"name": ek.username, "password": ek.password}, &authResp)
+		if err != nil {
+			return fmt.Errorf("etcd authentication failed (%s)", err)
+		}
+		token = authResp.Token
+	}
+
+	return ek.doWithToken(ctx, token, path, reqPayload, respPayload)
+}
+
+// doWithToken sends a json request to the etcd api using the specified token
+func (ek *etcdKV) doWithToken(ctx context.Context, token, path string, reqPayload any, respPayload any) error {
+	reqBody, err := json.Marshal(reqPayload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ek.address+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := ek.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error from etcd (status: %d, %s)", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	err = json.Unmarshal(respBody, respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to decode etcd response (%s)", err)
+	}
+
+	return nil
+}
+
+// get implements kvStore
+func (ek *etcdKV) get(ctx context.Context, keys []string) (map[string]kvEntry, error) {
+	// []byte are base64 in json, int64 are strings
+	var rangeResp struct {
+		Kvs []struct {
+			Key         []byte `json:"key"`
+			Value       []byte `json:"value"`
+			ModRevision int64  `json:"mod_revision,string"`
+		} `json:"kvs"`
+	}
+	err := ek.do(ctx, "/v3/kv/range", map[string][]byte{
+		"key":       []byte(ek.prefix),
+		"range_end": etcdPrefixEnd(ek.prefix),
+	}, &rangeResp)
+	if err != nil {
+		return nil, err
+	}
+
+	// only return requested keys
+	entries := map[string]kvEntry{}
+	for _, key := range keys {
+		for _, kv := range rangeResp.Kvs {
+			if string(kv.Key) == key {
+				entries[key] = kvEntry{value: kv.Value, revision: kv.ModRevision}
+				break
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// putAll implements kvStore
+func (ek *etcdKV) putAll(ctx context.Context, values map[string][]byte, revisions map[string]int64) error {
+	type etcdCompare struct {
+		Key         []byte `json:"key"`
+		Target      string `json:"target"`
+		Result      string `json:"result"`
+		ModRevision int64  `json:"mod_revision,string"`
+	}
+	type etcdPut struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	}
+
+	// each key must be unmodified (a mod revision of 0 means the key doesn't exist)
+	compares := []etcdCompare{}
+	puts := []map[string]etcdPut{}
+	for key, value := range values {
+		compares = append(compares, etcdCompare{
+			Key:         []byte(key),
+			Target:      "MOD",
+			Result:      "EQUAL",
+			ModRevision: revisions[key],
+		})
+		puts = append(puts, map[string]etcdPut{
+			"request_put": {Key: []byte(key), Value: value},
+		})
+	}
+
+	var txnResp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	err := ek.do(ctx, "/v3/kv/txn", map[string]any{
+		"compare": compares,
+		"success": puts,
+	}, &txnResp)
+	if err != nil {
+		return err
+	}
+
+	if !txnResp.Succeeded {
+		return errKVConflict
+	}
+
+	return nil
+}
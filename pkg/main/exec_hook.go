@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hook stages
+const (
+	execHookStagePreWrite  = "pre-write"
+	execHookStagePostWrite = "post-write"
+)
+
+// execHook is a command that is run before or after new files are written (e.g. to
+// reload a service on a host without docker)
+type execHook struct {
+	command string
+	timeout time.Duration
+	dir     string
+	// credential of the user to run as (nil is the client's user)
+	credential *execHookCredential
+	// env is additional environment (KEY=VALUE) for the command
+	env []string
+}
+
+// execHookEnv returns the environment that describes the write to hooks
+func (app *app) execHookEnv(stage string, certPem []byte, filenames []string) []string {
+	paths := []string{}
+	for _, filename := range filenames {
+		paths = append(paths, filepath.Join(app.cfg.CertStoragePath, filename))
+	}
+
+	env := []string{
+		"CW_HOOK_STAGE=" + stage,
+		"CW_HOOK_CERT_PATH=" + app.cfg.CertStoragePath,
+		"CW_HOOK_CHANGED_FILES=" + strings.Join(paths, " "),
+	}
+
+	cert, _, err := certPemToCerts(certPem)
+	if err == nil {
+		fingerprint, _ := certFingerprint(certPem)
+		env = append(env,
+			"CW_HOOK_CERT_FINGERPRINT="+fingerprint,
+			"CW_HOOK_CERT_SERIAL="+cert.SerialNumber.Text(16),
+			"CW_HOOK_CERT_NOT_AFTER="+cert.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+
+	return env
+}
+
+// run runs the hook's command with the specified additional environment and returns
+// its combined output
+func (hook *execHook) run(env []string) (output []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook.command)
+	cmd.Dir = hook.dir
+	cmd.Env = append(append(os.Environ(), hook.env...), env...)
+	setExecHookProcAttr(cmd, hook.credential)
+	// don't wait forever on output of a child that left the group
+	cmd.WaitDelay = 5 * time.Second
+
+	output, err = cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("timed out after %s", hook.timeout)
+	}
+
+	return output, err
+}
+
+// runExecHooks runs the hooks, in order, and logs the results. If stopOnFailure, the
+// remaining hooks are not run after one fails.
+func (app *app) runExecHooks(stage string, hooks []execHook, env []string, stopOnFailure bool) (failedAny bool) {
+	for i, hook := range hooks {
+		output, err := hook.run(env)
+
+		outputStr := strings.TrimSpace(string(output))
+		if outputStr != "" {
+			app.logger.Debugf("%s hook %d output: %s", stage, i, outputStr)
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			app.logger.Errorf("%s hook %d (%s) failed with exit code %d", stage, i, hook.command, exitErr.ExitCode())
+		} else if err != nil {
+			app.logger.Errorf("%s hook %d (%s) failed (%s)", stage, i, hook.command, err)
+		} else {
+			app.logger.Infof("%s hook %d (%s) succeeded with exit code 0", stage, i, hook.command)
+		}
+
+		if err != nil {
+			failedAny = true
+			if stopOnFailure {
+				return failedAny
+			}
+		}
+	}
+
+	return failedAny
+}
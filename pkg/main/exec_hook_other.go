@@ -0,0 +1,19 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// execHookCredential is the user and group a hook runs as
+type execHookCredential struct{}
+
+// parseExecHookUser returns the credential for a user name, uid, or uid:gid
+func parseExecHookUser(_ string) (*execHookCredential, error) {
+	return nil, fmt.Errorf("hook user %w", errUnsupportedOS)
+}
+
+// setExecHookProcAttr runs cmd as credential (nil is the client's user)
+func setExecHookProcAttr(_ *exec.Cmd, _ *execHookCredential) {}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// execHookCredential is the user and group a hook runs as
+type execHookCredential = syscall.Credential
+
+// parseExecHookUser returns the credential for a user name, uid, or uid:gid
+func parseExecHookUser(userStr string) (*execHookCredential, error) {
+	uidStr, gidStr, hasGid := strings.Cut(userStr, ":")
+	if !hasGid {
+		u, err := user.Lookup(userStr)
+		if err != nil {
+			u, err = user.LookupId(userStr)
+			if err != nil {
+				return nil, fmt.Errorf("unknown user %s", userStr)
+			}
+		}
+		uidStr, gidStr = u.Uid, u.Gid
+	}
+
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %s", uidStr)
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %s", gidStr)
+	}
+
+	return &execHookCredential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+// setExecHookProcAttr runs cmd as credential (nil is the client's user), in its own
+// process group so a timeout kills any children too
+func setExecHookProcAttr(cmd *exec.Cmd, credential *execHookCredential) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
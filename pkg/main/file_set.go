@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// versioned file set layout (same idea as Kubernetes' volume AtomicWriter):
+//
+//	<dir>/..2025_01_22_03_00_00.123456789 (version dir that contains the real files)
+//	<dir>/..data -> ..2025_01_22_03_00_00.123456789
+//	<dir>/key.pem -> ..data/key.pem
+//	<dir>/certchain.pem -> ..data/certchain.pem
+//
+// A new set is made by writing a complete new version dir and then atomically swapping
+// the ..data symlink, so a reader sees either the entire old set or the entire new set.
+const (
+	versionedSetDataLink    = "..data"
+	versionedSetDirPrefix   = ".."
+	versionedSetTimeFormat  = "2006_01_02_15_04_05."
+	versionedSetTmpLinkName = "..data_tmp"
+)
+
+// writeFileSetVersioned writes a complete new version of the file set to dir. files
+// contains the files that changed; any other filename listed in managedFilenames that
+// exists is carried over, unchanged, from the current version.
+func writeFileSetVersioned(dir string, files []fileWrite, managedFilenames []string) error {
+	// build complete set (changed files + unchanged current files)
+	completeSet := []fileWrite{}
+	changed := make(map[string]struct{})
+	for i := range files {
+		changed[files[i].filename] = struct{}{}
+		completeSet = append(completeSet, files[i])
+	}
+	for _, name := range managedFilenames {
+		if _, ok := changed[name]; ok {
+			continue
+		}
+
+		data, perm, err := readManagedFile(dir, name)
+		if errors.Is(err, os.ErrNotExist) {
+			// not in the current set (e.g. removed by a rollback)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to carry %s over to new file set (%s)", name, err)
+		}
+		completeSet = append(completeSet, fileWrite{filename: name, data: data, perm: perm})
+	}
+
+	// make new version dir
+	versionDir, err := os.MkdirTemp(dir, versionedSetDirPrefix+time.Now().Format(versionedSetTimeFormat))
+	if err != nil {
+		return fmt.Errorf("failed to make version dir (%s)", err)
+	}
+	versionDirName := filepath.Base(versionDir)
+
+	err = writeVersionDir(versionDir, completeSet)
+	if err != nil {
+		_ = os.RemoveAll(versionDir)
+		return err
+	}
+
+	// record old version (to cleanup after swap)
+	oldVersionDirName, _ := os.Readlink(filepath.Join(dir, versionedSetDataLink))
+
+	// swap data link to new version
+	tmpLink := filepath.Join(dir, versionedSetTmpLinkName)
+	_ = os.Remove(tmpLink)
+	err = os.Symlink(versionDirName, tmpLink)
+	if err != nil {
+		_ = os.RemoveAll(versionDir)
+		return fmt.Errorf("failed to make new data symlink (%s)", err)
+	}
+	err = os.Rename(tmpLink, filepath.Join(dir, versionedSetDataLink))
+	if err != nil {
+		_ = os.Remove(tmpLink)
+		_ = os.RemoveAll(versionDir)
+		return fmt.Errorf("failed to swap data symlink (%s)", err)
+	}
+	err = syncDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// ensure each file is a symlink into the data link (only changes on first run or
+	// if a new file was added to the config)
+	for i := range completeSet {
+		err = ensureVersionedSetLink(dir, completeSet[i].filename)
+		if err != nil {
+			return err
+		}
+	}
+	err = syncDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// remove old version
+	if oldVersionDirName != "" && oldVersionDirName != versionDirName && strings.HasPrefix(oldVersionDirName, versionedSetDirPrefix) {
+		_ = os.RemoveAll(filepath.Join(dir, oldVersionDirName))
+	}
+
+	return nil
+}
+
+// writeVersionDir writes and syncs each file into versionDir
+func writeVersionDir(versionDir string, files []fileWrite) error {
+	err := os.Chmod(versionDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to chmod version dir (%s)", err)
+	}
+
+	for i := range files {
+		f, err := os.OpenFile(filepath.Join(versionDir, files[i].filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, files[i].perm)
+		if err != nil {
+			return fmt.Errorf("failed to create %s (%s)", files[i].filename, err)
+		}
+
+		err = f.Chmod(files[i].perm)
+		if err == nil {
+			_, err = f.Write(files[i].data)
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s (%s)", files[i].filename, err)
+		}
+	}
+
+	return syncDir(versionDir)
+}
+
+// ensureVersionedSetLink makes filename in dir a symlink to the same filename in
+// the data link, replacing anything else (e.g. a regular file) that is there
+func ensureVersionedSetLink(dir, filename string) error {
+	linkPath := filepath.Join(dir, filename)
+	target := filepath.Join(versionedSetDataLink, filename)
+
+	current, err := os.Readlink(linkPath)
+	if err == nil && current == target {
+		return nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// exists but is not a symlink, confirm it isn't a dir
+		info, statErr := os.Lstat(linkPath)
+		if statErr == nil && info.IsDir() {
+			return fmt.Errorf("cannot replace directory %s with symlink", filename)
+		}
+	}
+
+	tmpLink := linkPath + ".tmplink"
+	_ = os.Remove(tmpLink)
+	err = os.Symlink(target, tmpLink)
+	if err != nil {
+		return fmt.Errorf("failed to make symlink for %s (%s)", filename, err)
+	}
+
+	err = os.Rename(tmpLink, linkPath)
+	if err != nil {
+		_ = os.Remove(tmpLink)
+		return fmt.Errorf("failed to swap in symlink for %s (%s)", filename, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testVersionDirs returns the version dirs in dir
+func testVersionDirs(t *testing.T, dir string) []string {
+	t.Helper()
+
+	versionDirs := []string{}
+	for _, entry := range testDirEntries(t, dir) {
+		if entry != versionedSetDataLink && strings.HasPrefix(entry, versionedSetDirPrefix) {
+			versionDirs = append(versionDirs, entry)
+		}
+	}
+	return versionDirs
+}
+
+func TestWriteFileSetVersioned(t *testing.T) {
+	dir := t.TempDir()
+	managed := []string{"key.pem", "certchain.pem"}
+
+	err := writeFileSetVersioned(dir, []fileWrite{
+		{filename: "key.pem", data: []byte("key 1"), perm: 0600},
+		{filename: "certchain.pem", data: []byte("cert 1"), perm: 0644},
+	}, managed)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	firstVersionDirs := testVersionDirs(t, dir)
+
+	// only the cert changed
+	err = writeFileSetVersioned(dir, []fileWrite{
+		{filename: "certchain.pem", data: []byte("cert 2"), perm: 0644},
+	}, managed)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	// every managed name points into the current version
+	versionDir, err := os.Readlink(filepath.Join(dir, versionedSetDataLink))
+	if err != nil {
+		t.Fatalf("data link is not a symlink (%s)", err)
+	}
+	for _, name := range managed {
+		target, err := os.Readlink(filepath.Join(dir, name))
+		if err != nil || target != filepath.Join(versionedSetDataLink, name) {
+			t.Errorf("%s link = %s (%v), want %s", name, target, err, filepath.Join(versionedSetDataLink, name))
+		}
+		_, err = os.Stat(filepath.Join(dir, versionDir, name))
+		if err != nil {
+			t.Errorf("%s is not in the current version (%s)", name, err)
+		}
+	}
+
+	// unchanged key is carried forward, with its perm
+	data, perm, _ := readManagedFile(dir, "key.pem")
+	if string(data) != "key 1" || perm != 0600 {
+		t.Errorf("key.pem = (%q, %o), want (\"key 1\", 600)", data, perm)
+	}
+	data, _, _ = readManagedFile(dir, "certchain.pem")
+	if string(data) != "cert 2" {
+		t.Errorf("certchain.pem = %q, want \"cert 2\"", data)
+	}
+
+	// old version is removed
+	versionDirs := testVersionDirs(t, dir)
+	if len(versionDirs) != 1 || versionDirs[0] != versionDir || versionDir == firstVersionDirs[0] {
+		t.Errorf("version dirs = %v, want only %s", versionDirs, versionDir)
+	}
+}
+
+func TestWriteFileSetVersionedReplacesFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	// regular files from before versioned sets were enabled
+	err := os.WriteFile(filepath.Join(dir, "key.pem"), []byte("key 1"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFileSetVersioned(dir, []fileWrite{
+		{filename: "certchain.pem", data: []byte("cert 2"), perm: 0644},
+	}, []string{"key.pem", "certchain.pem"})
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(dir, "key.pem"))
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("key.pem was not replaced by a symlink (%v)", err)
+	}
+	data, _, _ := readManagedFile(dir, "key.pem")
+	if string(data) != "key 1" {
+		t.Errorf("key.pem = %q, want \"key 1\"", data)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fileWrite is a file that should be written to the cert storage path
+type fileWrite struct {
+	filename string
+	data     []byte
+	perm     fs.FileMode
+}
+
+// writeFileAtomic writes data to the named file without ever exposing a partially
+// written file. The data is written to a temp file in the same directory, synced,
+// and then renamed over the named file. Lastly, the directory is synced so the
+// rename itself is durable.
+func writeFileAtomic(name string, data []byte, perm fs.FileMode) (err error) {
+	dir := filepath.Dir(name)
+
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	// if anything fails, cleanup temp file
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpName)
+		}
+	}()
+
+	// CreateTemp always uses 0600, so set desired perm explicitly (this also
+	// avoids umask altering the perm)
+	err = tmpFile.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
+	// if replacing an existing file, try to keep its owner (same as os.WriteFile
+	// would have); this is best effort as it only works if permitted
+	if info, statErr := os.Stat(name); statErr == nil {
+		_ = chownLike(tmpFile, info)
+	}
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = tmpFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpName, name)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir fsyncs the specified directory so that changes to its entries (e.g. a
+// rename) are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	// some filesystems don't support sync on a directory, that isn't a failure
+	if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+
+	return nil
+}
+
+// writeFiles writes the specified files to the cert storage path. If versioned file
+// sets are enabled, the files are written together as a new complete set and swapped
+// in at once; otherwise each file is atomically written on its own.
+func (app *app) writeFiles(files []fileWrite) (wroteAny bool, failedAny bool) {
+	// versioned file set (all or nothing)
+	if app.cfg.FileVersionedSet {
+		err := writeFileSetVersioned(app.cfg.CertStoragePath, files, app.managedFilenames())
+		if err != nil {
+			app.logger.Errorf("failed to write new versioned file set (%s)", err)
+			return false, true
+		}
+
+		for i := range files {
+			app.logger.Infof("wrote new %s file", files[i].filename)
+		}
+		return true, false
+	}
+
+	// individual files
+	for i := range files {
+		err := writeFileAtomic(filepath.Join(app.cfg.CertStoragePath, files[i].filename), files[i].data, files[i].perm)
+		if err != nil {
+			app.logger.Errorf("failed to write %s (%s)", files[i].filename, err)
+			failedAny = true
+			// failed, but keep trying
+		} else {
+			app.logger.Infof("wrote new %s file", files[i].filename)
+			wroteAny = true
+		}
+	}
+
+	return wroteAny, failedAny
+}
+
+// managedFilenames returns the filenames of all of the files the client is configured
+// to write to the cert storage path
+func (app *app) managedFilenames() []string {
+	names := []string{"key.pem", "certchain.pem"}
+
+	for _, output := range app.additionalOutputFiles() {
+		names = append(names, output.filename)
+	}
+
+	return names
+}
+
+// readManagedFile reads the named file from the storage path and returns its content
+// and permissions
+func readManagedFile(dir, filename string) (data []byte, perm fs.FileMode, err error) {
+	path := filepath.Join(dir, filename)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s (%s)", filename, err)
+	}
+
+	return data, info.Mode().Perm(), nil
+}
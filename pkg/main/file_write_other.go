@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+)
+
+// errUnsupportedOS is returned by functionality that is only available on unix
+var errUnsupportedOS = fmt.Errorf("not supported on %s", runtime.GOOS)
+
+// chownLike changes the owner of f to the owner of the file described by info
+func chownLike(_ *os.File, _ fs.FileInfo) error {
+	return fmt.Errorf("file owner %w", errUnsupportedOS)
+}
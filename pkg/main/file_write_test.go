@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDirEntries returns the names of the entries in dir
+func testDirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "key.pem")
+
+	err := writeFileAtomic(name, []byte("old"), 0600)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	// replaces the file with the specified perm
+	err = writeFileAtomic(name, []byte("new"), 0640)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	data, perm, err := readManagedFile(dir, "key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" || perm != 0640 {
+		t.Errorf("file = (%q, %o), want (\"new\", 640)", data, perm)
+	}
+
+	if entries := testDirEntries(t, dir); len(entries) != 1 {
+		t.Errorf("dir entries = %v, want only key.pem", entries)
+	}
+}
+
+func TestWriteFileAtomicFailed(t *testing.T) {
+	dir := t.TempDir()
+
+	// a non-empty dir can't be replaced by the rename
+	name := filepath.Join(dir, "certchain.pem")
+	err := os.MkdirAll(filepath.Join(name, "sub"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFileAtomic(name, []byte("new"), 0644)
+	if err == nil {
+		t.Fatal("write did not fail")
+	}
+
+	// temp file is removed
+	for _, entry := range testDirEntries(t, dir) {
+		if strings.Contains(entry, ".tmp") {
+			t.Errorf("temp file %s was left after the failed write", entry)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chownLike changes the owner of f to the owner of the file described by info
+func chownLike(f *os.File, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("file owner is not available")
+	}
+
+	return f.Chown(int(stat.Uid), int(stat.Gid))
+}
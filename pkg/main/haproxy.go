@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// makeCombinedPem returns the cert chain followed by the key in one pem (the format
+// haproxy loads for a crt)
+func makeCombinedPem(keyPem, certPem []byte) []byte {
+	combined := bytes.TrimRight(certPem, "\n")
+	combined = append(combined, '\n')
+	return append(combined, keyPem...)
+}
+
+// haproxyAction is a post write action that hot swaps a cert in haproxy using its
+// runtime api (`set ssl cert` and `commit ssl cert`), avoiding a reload
+type haproxyAction struct {
+	// network and address of the runtime api socket (unix or tcp)
+	network string
+	address string
+	// certPath is the path of the cert as it is loaded in haproxy's config (crt ...)
+	certPath  string
+	keyFormat string
+	// fallbackContainer (optional) is the docker container of this haproxy, it is updated
+	// if the hot load fails (haproxy then loads the new cert from disk)
+	fallbackContainer string
+	// updateContainer updates a docker container (see app.updateDockerContainerAndWait)
+	updateContainer func(container dockerContainer) error
+}
+
+// parseHAProxySocket parses a socket address in the form unix:/path or tcp:host:port
+// (a bare path is treated as unix)
+func parseHAProxySocket(socket string) (network string, address string, err error) {
+	if strings.HasPrefix(socket, "tcp:") {
+		address = strings.TrimPrefix(socket, "tcp:")
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("invalid tcp address %s (%s)", address, err)
+		}
+		return "tcp", address, nil
+	}
+
+	address = strings.TrimPrefix(socket, "unix:")
+	if address == "" {
+		return "", "", errors.New("socket path is blank")
+	}
+
+	return "unix", address, nil
+}
+
+// name implements postWriteAction
+func (ha *haproxyAction) name() string {
+	return fmt.Sprintf("haproxy runtime api (%s %s)", ha.address, ha.certPath)
+}
+
+// command sends a single command to the runtime api and returns the response
+func (ha *haproxyAction) command(ctx context.Context, cmd string) (string, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, ha.network, ha.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(1 * time.Minute)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	// non-interactive mode, haproxy closes the connection after the response
+	_, err = io.WriteString(conn, cmd+"\n")
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	// single line (for logging)
+	return strings.Join(strings.Fields(string(resp)), " "), nil
+}
+
+// run implements postWriteAction; it hot loads the new cert and updates the fallback
+// container (if configured) if that fails
+func (ha *haproxyAction) run(ctx context.Context, keyPem, certPem []byte) error {
+	err := ha.hotLoad(ctx, keyPem, certPem)
+	if err == nil || ha.fallbackContainer == "" {
+		return err
+	}
+
+	// always restart (not CW_CLIENT_RESTART_DOCKER_STOP_ONLY's stop)
+	updateErr := ha.updateContainer(dockerContainer{Name: ha.fallbackContainer, Action: dockerActionRestart})
+	if updateErr != nil {
+		return fmt.Errorf("%s and updating fallback container %s failed (%s)", err, ha.fallbackContainer, updateErr)
+	}
+
+	return fmt.Errorf("%s, updated fallback container %s instead", err, ha.fallbackContainer)
+}
+
+// hotLoad sets and commits the new cert in haproxy
+func (ha *haproxyAction) hotLoad(ctx context.Context, keyPem, certPem []byte) error {
+	keyPem, err := convertKeyPem(keyPem, ha.keyFormat)
+	if err != nil {
+		return err
+	}
+
+	// payload is the combined pem (without blank lines, a blank line ends the payload)
+	payload := bytes.ReplaceAll(bytes.TrimSpace(makeCombinedPem(keyPem, certPem)), []byte("\n\n"), []byte("\n"))
+
+	resp, err := ha.command(ctx, "set ssl cert "+ha.certPath+" <<\n"+string(payload)+"\n")
+	if err != nil {
+		return fmt.Errorf("set ssl cert failed (%s)", err)
+	}
+	if !strings.HasPrefix(resp, "Transaction created") && !strings.HasPrefix(resp, "Transaction updated") {
+		return fmt.Errorf("set ssl cert failed (haproxy response: %s)", resp)
+	}
+
+	resp, err = ha.command(ctx, "commit ssl cert "+ha.certPath)
+	if err == nil && !strings.Contains(resp, "Success!") {
+		err = fmt.Errorf("haproxy response: %s", resp)
+	}
+	if err != nil {
+		// don't leave the transaction open
+		_, _ = ha.command(ctx, "abort ssl cert "+ha.certPath)
+		return fmt.Errorf("commit ssl cert failed (%s)", err)
+	}
+
+	return nil
+}
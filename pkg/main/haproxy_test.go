@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeHAProxyRuntimeAPI is a haproxy runtime api (non-interactive mode) that records
+// the commands it receives
+type fakeHAProxyRuntimeAPI struct {
+	mu       sync.Mutex
+	commands []string
+	// payloads of set ssl cert commands
+	payloads []string
+	// responses maps a command verb (e.g. "set", "commit") to its response
+	responses map[string]string
+}
+
+// serve handles connections on ln, one command per connection
+func (fh *fakeHAProxyRuntimeAPI) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+		cmd, _ := reader.ReadString('\n')
+		cmd = strings.TrimSuffix(cmd, "\n")
+
+		// payload ends with a blank line
+		payload := []string{}
+		if strings.HasSuffix(cmd, "<<") {
+			for {
+				line, err := reader.ReadString('\n')
+				line = strings.TrimSuffix(line, "\n")
+				if err != nil || line == "" {
+					break
+				}
+				payload = append(payload, line)
+			}
+		}
+
+		fh.mu.Lock()
+		fh.commands = append(fh.commands, cmd)
+		if len(payload) > 0 {
+			fh.payloads = append(fh.payloads, strings.Join(payload, "\n"))
+		}
+		verb, _, _ := strings.Cut(cmd, " ")
+		resp := fh.responses[verb]
+		fh.mu.Unlock()
+
+		_, _ = conn.Write([]byte(resp + "\n\n"))
+		_ = conn.Close()
+	}
+}
+
+// testHAProxy starts a fake runtime api and returns it with an action that uses it
+func testHAProxy(t *testing.T) (*fakeHAProxyRuntimeAPI, *haproxyAction) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	fake := &fakeHAProxyRuntimeAPI{
+		responses: map[string]string{
+			"set":    "Transaction created for certificate /etc/haproxy/certs/site.pem!",
+			"commit": "Committing /etc/haproxy/certs/site.pem\nSuccess!",
+			"abort":  "Transaction aborted for certificate '/etc/haproxy/certs/site.pem'!",
+		},
+	}
+	go fake.serve(ln)
+
+	return fake, &haproxyAction{
+		network:  "tcp",
+		address:  ln.Addr().String(),
+		certPath: "/etc/haproxy/certs/site.pem",
+	}
+}
+
+func TestHAProxyActionRun(t *testing.T) {
+	fake, ha := testHAProxy(t)
+
+	keyPem, leafPem := testKeyCert(t, 1)
+	_, chainPem := testKeyCert(t, 2)
+	// blank line between the certs, which would end the payload early
+	certPem := bytes.Join([][]byte{leafPem, chainPem}, []byte("\n"))
+
+	err := ha.run(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	want := []string{"set ssl cert /etc/haproxy/certs/site.pem <<", "commit ssl cert /etc/haproxy/certs/site.pem"}
+	if strings.Join(fake.commands, "; ") != strings.Join(want, "; ") {
+		t.Errorf("commands = %q, want %q", fake.commands, want)
+	}
+
+	// payload is the whole combined pem, without blank lines
+	wantPayload := string(bytes.TrimSpace(leafPem)) + "\n" + string(bytes.TrimSpace(chainPem)) + "\n" + string(bytes.TrimSpace(keyPem))
+	if len(fake.payloads) != 1 || fake.payloads[0] != wantPayload {
+		t.Errorf("payload = %q, want %q", fake.payloads, wantPayload)
+	}
+}
+
+func TestHAProxyActionCommitFailed(t *testing.T) {
+	fake, ha := testHAProxy(t)
+	fake.responses["commit"] = "Committing /etc/haproxy/certs/site.pem\nunable to load certificate"
+
+	keyPem, certPem := testKeyCert(t, 1)
+	err := ha.run(context.Background(), keyPem, certPem)
+	if err == nil {
+		t.Fatal("run did not fail")
+	}
+
+	// transaction is aborted
+	want := []string{"set ssl cert /etc/haproxy/certs/site.pem <<", "commit ssl cert /etc/haproxy/certs/site.pem", "abort ssl cert /etc/haproxy/certs/site.pem"}
+	if strings.Join(fake.commands, "; ") != strings.Join(want, "; ") {
+		t.Errorf("commands = %q, want %q", fake.commands, want)
+	}
+}
+
+func TestHAProxyActionFallbackContainer(t *testing.T) {
+	fake, ha := testHAProxy(t)
+	fake.responses["set"] = "Unknown command"
+
+	updated := []dockerContainer{}
+	ha.fallbackContainer = "haproxy"
+	ha.updateContainer = func(container dockerContainer) error {
+		updated = append(updated, container)
+		return nil
+	}
+
+	keyPem, certPem := testKeyCert(t, 1)
+	err := ha.run(context.Background(), keyPem, certPem)
+	if err == nil || !strings.Contains(err.Error(), "updated fallback container haproxy") {
+		t.Errorf("err = %v, want fallback container updated", err)
+	}
+
+	// no commit without a transaction
+	if len(fake.commands) != 1 {
+		t.Errorf("commands = %q, want only set", fake.commands)
+	}
+
+	// restarted, even if containers are otherwise stopped
+	if len(updated) != 1 || updated[0].Name != "haproxy" || updated[0].Action != dockerActionRestart {
+		t.Errorf("updated containers = %+v, want haproxy restarted", updated)
+	}
+
+	// fallback update failure is included
+	ha.updateContainer = func(container dockerContainer) error {
+		return errors.New("no such container")
+	}
+	err = ha.run(context.Background(), keyPem, certPem)
+	if err == nil || !strings.Contains(err.Error(), "no such container") {
+		t.Errorf("err = %v, want fallback container update failure", err)
+	}
+}
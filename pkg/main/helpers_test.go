@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+// testApp returns an app that logs to the test and stores files in a temp dir
+func testApp(t *testing.T) *app {
+	t.Helper()
+
+	return &app{
+		logger:            zaptest.NewLogger(t).Sugar(),
+		shutdownContext:   context.Background(),
+		shutdownWaitgroup: new(sync.WaitGroup),
+		tlsCert:           NewSafeCert(),
+		cfg: &config{
+			CertStoragePath: t.TempDir(),
+			KeyPermissions:  0600,
+			CertPermissions: 0644,
+		},
+	}
+}
+
+// testKeyCert returns a new self signed ECDSA key/cert pem pair with the specified serial
+func testKeyCert(t *testing.T, serial int64) (keyPem, certPem []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+// encryptedKeyCiphers maps config cipher names to the PKCS#8 cipher
+var encryptedKeyCiphers = map[string]pkcs8.Cipher{
+	"aes-128-cbc":  pkcs8.AES128CBC,
+	"aes-192-cbc":  pkcs8.AES192CBC,
+	"aes-256-cbc":  pkcs8.AES256CBC,
+	"aes-128-gcm":  pkcs8.AES128GCM,
+	"aes-192-gcm":  pkcs8.AES192GCM,
+	"aes-256-gcm":  pkcs8.AES256GCM,
+	"des-ede3-cbc": pkcs8.TripleDESCBC,
+}
+
+// encryptedKeyKDFs maps config kdf names to the PKCS#8 kdf options
+var encryptedKeyKDFs = map[string]pkcs8.KDFOpts{
+	"pbkdf2-sha256": pkcs8.PBKDF2Opts{
+		SaltSize:       16,
+		IterationCount: 600000,
+		HMACHash:       crypto.SHA256,
+	},
+	"pbkdf2-sha1": pkcs8.PBKDF2Opts{
+		SaltSize:       16,
+		IterationCount: 600000,
+		HMACHash:       crypto.SHA1,
+	},
+	"scrypt": pkcs8.ScryptOpts{
+		SaltSize:                 16,
+		CostParameter:            1 << 14,
+		BlockSize:                8,
+		ParallelizationParameter: 1,
+	},
+}
+
+// parseEncryptedKeyOpts returns the PKCS#8 encryption options for the specified cipher
+// and kdf names
+func parseEncryptedKeyOpts(cipherName, kdfName string) (*pkcs8.Opts, error) {
+	cipher, ok := encryptedKeyCiphers[strings.ToLower(cipherName)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher \"%s\"", cipherName)
+	}
+
+	kdf, ok := encryptedKeyKDFs[strings.ToLower(kdfName)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kdf \"%s\"", kdfName)
+	}
+
+	return &pkcs8.Opts{
+		Cipher:  cipher,
+		KDFOpts: kdf,
+	}, nil
+}
+
+// makeEncryptedKeyPem returns the private key from keyPem as an encrypted PKCS#8 pem
+func makeEncryptedKeyPem(keyPem []byte, password string, opts *pkcs8.Opts) (encKeyPem []byte, err error) {
+	// get private key
+	key, err := keyPemToKey(keyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	// encrypt
+	der, err := pkcs8.MarshalPrivateKey(key, []byte(password), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
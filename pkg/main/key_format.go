@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errKeyFormatUnsupported is returned when a key can never be converted to a format
+// because the format doesn't support the type of key
+var errKeyFormatUnsupported = errors.New("unsupported key type")
+
+// key formats that a key pem can be converted to
+const (
+	keyFormatUnchanged = ""
+	keyFormatPKCS1     = "pkcs1"
+	keyFormatSEC1      = "sec1"
+	keyFormatPKCS8     = "pkcs8"
+)
+
+// parseKeyFormat returns the key format for the specified string, or an error if
+// it isn't a valid format
+func parseKeyFormat(format string) (string, error) {
+	format = strings.ToLower(format)
+
+	switch format {
+	case keyFormatUnchanged, keyFormatPKCS1, keyFormatSEC1, keyFormatPKCS8:
+		return format, nil
+
+	default:
+		// fallthrough
+	}
+
+	return "", fmt.Errorf("invalid key format \"%s\" (valid formats: %s, %s, %s)", format, keyFormatPKCS1, keyFormatSEC1, keyFormatPKCS8)
+}
+
+// keyTypeName returns a friendly name for the type of key (for errors)
+func keyTypeName(key any) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa"
+	case *ecdsa.PrivateKey:
+		return "ecdsa " + k.Curve.Params().Name
+	case ed25519.PrivateKey:
+		return "ed25519"
+	default:
+		// fallthrough
+	}
+
+	return fmt.Sprintf("%T", key)
+}
+
+// convertKeyPem re-encodes keyPem in the specified format. If format is unchanged,
+// keyPem is returned as-is.
+func convertKeyPem(keyPem []byte, format string) (convertedPem []byte, err error) {
+	if format == keyFormatUnchanged {
+		return keyPem, nil
+	}
+
+	// get private key
+	key, err := keyPemToKey(keyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	// encode in desired format
+	var block *pem.Block
+	switch format {
+	case keyFormatPKCS1:
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s format only supports rsa keys (key is %s)", errKeyFormatUnsupported, format, keyTypeName(key))
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+
+	case keyFormatSEC1:
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s format only supports ecdsa keys (key is %s)", errKeyFormatUnsupported, format, keyTypeName(key))
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s key in %s format (%s)", keyTypeName(key), format, err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+
+	case keyFormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s key in %s format (%s)", keyTypeName(key), format, err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+
+	default:
+		return nil, fmt.Errorf("invalid key format \"%s\"", format)
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// checkKeyFormats returns an error if keyPem's type of key can't be converted to one of
+// the configured key formats (key.pem's, which is also used by other outputs, and those
+// of the additional key outputs)
+func (app *app) checkKeyFormats(keyPem []byte) error {
+	formats := []string{app.cfg.KeyFormat}
+	for _, keyOutput := range app.cfg.KeyOutputs {
+		formats = append(formats, keyOutput.Format)
+	}
+
+	for _, format := range formats {
+		_, err := convertKeyPem(keyPem, format)
+		if errors.Is(err, errKeyFormatUnsupported) {
+			return err
+		}
+	}
+
+	return nil
+}
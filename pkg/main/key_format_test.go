@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// testKeyPems returns a pkcs8 key pem for each supported type of key
+func testKeyPems(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPems := map[string][]byte{}
+	for name, key := range map[string]any{"rsa": rsaKey, "ecdsa": ecKey, "ed25519": edKey} {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPems[name] = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	return keyPems
+}
+
+func TestConvertKeyPem(t *testing.T) {
+	keyPems := testKeyPems(t)
+
+	tests := []struct {
+		keyType   string
+		format    string
+		blockType string
+	}{
+		{"rsa", keyFormatPKCS1, "RSA PRIVATE KEY"},
+		{"rsa", keyFormatSEC1, ""},
+		{"rsa", keyFormatPKCS8, "PRIVATE KEY"},
+		{"ecdsa", keyFormatPKCS1, ""},
+		{"ecdsa", keyFormatSEC1, "EC PRIVATE KEY"},
+		{"ecdsa", keyFormatPKCS8, "PRIVATE KEY"},
+		{"ed25519", keyFormatPKCS1, ""},
+		{"ed25519", keyFormatSEC1, ""},
+		{"ed25519", keyFormatPKCS8, "PRIVATE KEY"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.keyType+" "+test.format, func(t *testing.T) {
+			keyPem := keyPems[test.keyType]
+			converted, err := convertKeyPem(keyPem, test.format)
+
+			// unsupported pair
+			if test.blockType == "" {
+				if !errors.Is(err, errKeyFormatUnsupported) {
+					t.Fatalf("err = %v, want errKeyFormatUnsupported", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertKeyPem failed: %s", err)
+			}
+
+			block, _ := pem.Decode(converted)
+			if block == nil || block.Type != test.blockType {
+				t.Fatalf("converted pem is not a %s block", test.blockType)
+			}
+
+			// same key
+			want, err := keyPemToKey(keyPem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := keyPemToKey(converted)
+			if err != nil {
+				t.Fatalf("converted key does not parse: %s", err)
+			}
+			if !got.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(want.(crypto.Signer).Public()) {
+				t.Error("converted key does not match")
+			}
+		})
+	}
+
+	// unchanged returns the pem as-is
+	converted, err := convertKeyPem(keyPems["rsa"], keyFormatUnchanged)
+	if err != nil || string(converted) != string(keyPems["rsa"]) {
+		t.Error("unchanged format did not return the key pem as-is")
+	}
+
+	// invalid format isn't a key type problem
+	_, err = convertKeyPem(keyPems["rsa"], "pem")
+	if err == nil || errors.Is(err, errKeyFormatUnsupported) {
+		t.Errorf("err = %v, want an invalid format error", err)
+	}
+}
+
+func TestCheckKeyFormats(t *testing.T) {
+	keyPems := testKeyPems(t)
+
+	app := &app{cfg: &config{KeyFormat: keyFormatPKCS8}}
+	err := app.checkKeyFormats(keyPems["ecdsa"])
+	if err != nil {
+		t.Fatalf("checkKeyFormats failed: %s", err)
+	}
+
+	// an additional key output that can't be used with the key
+	app.cfg.KeyOutputs = []keyOutputConfig{{Filename: "key.rsa.pem", Format: keyFormatPKCS1}}
+	err = app.checkKeyFormats(keyPems["ecdsa"])
+	if !errors.Is(err, errKeyFormatUnsupported) {
+		t.Errorf("err = %v, want errKeyFormatUnsupported", err)
+	}
+	err = app.checkKeyFormats(keyPems["rsa"])
+	if err != nil {
+		t.Errorf("checkKeyFormats failed: %s", err)
+	}
+}
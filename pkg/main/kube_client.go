@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// in-cluster service account files
+const (
+	kubeServiceAccountPath          = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeServiceAccountTokenFile     = kubeServiceAccountPath + "/token"
+	kubeServiceAccountCAFile        = kubeServiceAccountPath + "/ca.crt"
+	kubeServiceAccountNamespaceFile = kubeServiceAccountPath + "/namespace"
+)
+
+// kubeClient is a minimal client for the kubernetes api
+type kubeClient struct {
+	server     string
+	httpClient *http.Client
+	// namespace is the default namespace (from the service account or kubeconfig)
+	namespace string
+
+	// bearer token, tokenFile is re-read on each request since service account tokens
+	// are rotated
+	token     string
+	tokenFile string
+}
+
+// kubeConfigFile is the subset of a kubeconfig file that the client uses
+type kubeConfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeConfigData returns the content of either the inline base64 data or the file
+// (relative paths are relative to the kubeconfig file's dir)
+func kubeConfigData(inlineB64, file, kubeConfigDir string) ([]byte, error) {
+	if inlineB64 != "" {
+		return base64.StdEncoding.DecodeString(inlineB64)
+	}
+
+	if file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(kubeConfigDir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	return nil, nil
+}
+
+// newKubeClientFromKubeConfig makes a kubeClient using the current context of the
+// specified kubeconfig file
+func newKubeClientFromKubeConfig(kubeConfigPath string) (*kubeClient, error) {
+	kubeConfigBytes, err := os.ReadFile(kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig (%s)", err)
+	}
+	kubeConfigDir := filepath.Dir(kubeConfigPath)
+
+	var kubeConfig kubeConfigFile
+	err = yaml.Unmarshal(kubeConfigBytes, &kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig (%s)", err)
+	}
+
+	// find current context, cluster, and user
+	contextIdx := -1
+	for i := range kubeConfig.Contexts {
+		if kubeConfig.Contexts[i].Name == kubeConfig.CurrentContext {
+			contextIdx = i
+			break
+		}
+	}
+	if contextIdx == -1 {
+		return nil, fmt.Errorf("kubeconfig current-context \"%s\" not found", kubeConfig.CurrentContext)
+	}
+	kubeContext := kubeConfig.Contexts[contextIdx].Context
+
+	clusterIdx := -1
+	for i := range kubeConfig.Clusters {
+		if kubeConfig.Clusters[i].Name == kubeContext.Cluster {
+			clusterIdx = i
+			break
+		}
+	}
+	if clusterIdx == -1 {
+		return nil, fmt.Errorf("kubeconfig cluster \"%s\" not found", kubeContext.Cluster)
+	}
+	cluster := kubeConfig.Clusters[clusterIdx].Cluster
+
+	client := &kubeClient{
+		server:    strings.TrimSuffix(cluster.Server, "/"),
+		namespace: kubeContext.Namespace,
+	}
+	if client.server == "" {
+		return nil, fmt.Errorf("kubeconfig cluster \"%s\" has no server", kubeContext.Cluster)
+	}
+	if client.namespace == "" {
+		client.namespace = "default"
+	}
+
+	// tls config
+	tlsConfig := &tls.Config{
+		ServerName:         cluster.TLSServerName,
+		InsecureSkipVerify: cluster.InsecureSkipTLSVerify,
+	}
+
+	caPem, err := kubeConfigData(cluster.CertificateAuthorityData, cluster.CertificateAuthority, kubeConfigDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig cluster certificate authority (%s)", err)
+	}
+	if len(caPem) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, errors.New("kubeconfig cluster certificate authority does not contain any valid certificates")
+		}
+	}
+
+	// user auth (a context without a user is allowed, e.g. for a proxy)
+	if kubeContext.User != "" {
+		userIdx := -1
+		for i := range kubeConfig.Users {
+			if kubeConfig.Users[i].Name == kubeContext.User {
+				userIdx = i
+				break
+			}
+		}
+		if userIdx == -1 {
+			return nil, fmt.Errorf("kubeconfig user \"%s\" not found", kubeContext.User)
+		}
+		user := kubeConfig.Users[userIdx].User
+
+		client.token = user.Token
+		if user.TokenFile != "" {
+			client.tokenFile = user.TokenFile
+			if !filepath.IsAbs(client.tokenFile) {
+				client.tokenFile = filepath.Join(kubeConfigDir, client.tokenFile)
+			}
+		}
+
+		clientCertPem, err := kubeConfigData(user.ClientCertificateData, user.ClientCertificate, kubeConfigDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig user client certificate (%s)", err)
+		}
+		clientKeyPem, err := kubeConfigData(user.ClientKeyData, user.ClientKey, kubeConfigDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig user client key (%s)", err)
+		}
+		if len(clientCertPem) > 0 || len(clientKeyPem) > 0 {
+			clientCert, err := tls.X509KeyPair(clientCertPem, clientKeyPem)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kubeconfig user client certificate and key (%s)", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{clientCert}
+		}
+	}
+
+	client.httpClient = makeTLSHttpClient(tlsConfig)
+
+	return client, nil
+}
+
+// newKubeClientInCluster makes a kubeClient using the pod's service account
+func newKubeClientInCluster() (*kubeClient, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster (KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT not set)")
+	}
+
+	// verify token exists
+	_, err := os.Stat(kubeServiceAccountTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find service account token (%s)", err)
+	}
+
+	caPem, err := os.ReadFile(kubeServiceAccountCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account ca (%s)", err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caPem) {
+		return nil, errors.New("service account ca does not contain any valid certificates")
+	}
+
+	namespace := "default"
+	namespaceBytes, err := os.ReadFile(kubeServiceAccountNamespaceFile)
+	if err == nil && len(bytes.TrimSpace(namespaceBytes)) > 0 {
+		namespace = string(bytes.TrimSpace(namespaceBytes))
+	}
+
+	return &kubeClient{
+		server:     "https://" + net.JoinHostPort(host, port),
+		httpClient: makeTLSHttpClient(&tls.Config{RootCAs: rootCAs}),
+		namespace:  namespace,
+		tokenFile:  kubeServiceAccountTokenFile,
+	}, nil
+}
+
+// do sends a request to the kubernetes api and returns the response status code and
+// body. body is marshalled json (or nil for no body).
+func (kc *kubeClient) do(ctx context.Context, method, path, contentType string, body []byte) (statusCode int, respBody []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, kc.server+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// auth
+	token := kc.token
+	if kc.tokenFile != "" {
+		tokenBytes, err := os.ReadFile(kc.tokenFile)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read kubernetes token file (%s)", err)
+		}
+		token = string(bytes.TrimSpace(tokenBytes))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := kc.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, respBody, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// kube tls secret type and keys
+const (
+	kubeSecretTypeTLS = "kubernetes.io/tls"
+	kubeSecretCertKey = "tls.crt"
+	kubeSecretKeyKey  = "tls.key"
+	kubeSecretCAKey   = "ca.crt"
+)
+
+// kubeSecret is the subset of a kubernetes Secret that the client uses
+type kubeSecret struct {
+	APIVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   kubeSecretMeta    `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data"`
+}
+
+type kubeSecretMeta struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// kubeSecretOutput is an output backend that writes the key/cert to a kubernetes tls
+// Secret
+type kubeSecretOutput struct {
+	client    *kubeClient
+	namespace string
+	secret    string
+	includeCA bool
+	keyFormat string
+}
+
+// name implements outputBackend
+func (kso *kubeSecretOutput) name() string {
+	return fmt.Sprintf("kubernetes secret %s/%s", kso.namespace, kso.secret)
+}
+
+// secretPath returns the api path for the secret (or the namespace's secrets if name
+// is blank)
+func (kso *kubeSecretOutput) secretPath(name string) string {
+	path := "/api/v1/namespaces/" + url.PathEscape(kso.namespace) + "/secrets"
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
+
+// secretData returns the data the secret should contain
+func (kso *kubeSecretOutput) secretData(keyPem, certPem []byte) (map[string][]byte, error) {
+	keyPem, err := convertKeyPem(keyPem, kso.keyFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{
+		kubeSecretCertKey: certPem,
+		kubeSecretKeyKey:  keyPem,
+	}
+
+	if kso.includeCA {
+		caPem, err := makeCABundle(certPem, nil, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make %s (%s)", kubeSecretCAKey, err)
+		}
+		data[kubeSecretCAKey] = caPem
+	}
+
+	return data, nil
+}
+
+// status implements outputBackend
+func (kso *kubeSecretOutput) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	statusCode, body, err := kso.client.do(ctx, http.MethodGet, kso.secretPath(kso.secret), "", nil)
+	if err != nil {
+		return false, false, err
+	}
+
+	if statusCode == http.StatusNotFound {
+		return false, false, nil
+	} else if statusCode != http.StatusOK {
+		return false, false, fmt.Errorf("error getting secret (status: %d)", statusCode)
+	}
+
+	var existing kubeSecret
+	err = json.Unmarshal(body, &existing)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to decode secret (%s)", err)
+	}
+
+	if existing.Type != kubeSecretTypeTLS {
+		return false, false, fmt.Errorf("secret exists but its type is %s (not %s)", existing.Type, kubeSecretTypeTLS)
+	}
+
+	desiredData, err := kso.secretData(keyPem, certPem)
+	if err != nil {
+		return false, false, err
+	}
+
+	for k, v := range desiredData {
+		if !bytes.Equal(existing.Data[k], v) {
+			return true, false, nil
+		}
+	}
+
+	// ca.crt left from when it was included
+	if _, exists := existing.Data[kubeSecretCAKey]; exists && !kso.includeCA {
+		return true, false, nil
+	}
+
+	return true, true, nil
+}
+
+// patchSecret merge patches the secret's data (leaving any other data, labels, etc. as-is)
+// and returns the response status code
+func (kso *kubeSecretOutput) patchSecret(ctx context.Context, data map[string][]byte) (int, error) {
+	// remove ca.crt if it isn't included (a nil value marshals to null, which deletes the
+	// key in a merge patch)
+	if !kso.includeCA {
+		patchData := make(map[string][]byte, len(data)+1)
+		for k, v := range data {
+			patchData[k] = v
+		}
+		patchData[kubeSecretCAKey] = nil
+		data = patchData
+	}
+
+	patch, err := json.Marshal(kubeSecret{Data: data})
+	if err != nil {
+		return 0, err
+	}
+
+	statusCode, _, err := kso.client.do(ctx, http.MethodPatch, kso.secretPath(kso.secret), "application/merge-patch+json", patch)
+	return statusCode, err
+}
+
+// write implements outputBackend
+func (kso *kubeSecretOutput) write(ctx context.Context, keyPem, certPem []byte) error {
+	data, err := kso.secretData(keyPem, certPem)
+	if err != nil {
+		return err
+	}
+
+	// try to update the existing secret
+	statusCode, err := kso.patchSecret(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return nil
+
+	case http.StatusNotFound:
+		// doesn't exist, create it below
+
+	default:
+		return fmt.Errorf("error updating secret (status: %d)", statusCode)
+	}
+
+	// create
+	secret, err := json.Marshal(kubeSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubeSecretMeta{
+			Name:      kso.secret,
+			Namespace: kso.namespace,
+		},
+		Type: kubeSecretTypeTLS,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	statusCode, _, err = kso.client.do(ctx, http.MethodPost, kso.secretPath(""), "application/json", secret)
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+
+	case http.StatusConflict:
+		// created by something else since the patch, update it instead
+		statusCode, err = kso.patchSecret(ctx, data)
+		if err != nil {
+			return err
+		}
+		if statusCode != http.StatusOK {
+			return fmt.Errorf("error updating secret that was created concurrently (status: %d)", statusCode)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("error creating secret (status: %d)", statusCode)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKubeAPI is an in memory kubernetes api server for secrets in one namespace
+type fakeKubeAPI struct {
+	mu      sync.Mutex
+	secrets map[string]*kubeSecret
+	// createdConcurrently makes the next create fail with a conflict, as if the secret
+	// was created by something else after the client's patch found it didn't exist
+	createdConcurrently *kubeSecret
+	requests            []string
+}
+
+func (fk *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+
+	fk.requests = append(fk.requests, r.Method)
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/api/v1/namespaces/certs/secrets"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && name != "":
+		secret, ok := fk.secrets[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(secret)
+
+	case r.Method == http.MethodPatch && name != "":
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		secret, ok := fk.secrets[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		patch := kubeSecret{}
+		_ = json.Unmarshal(body, &patch)
+		for k, v := range patch.Data {
+			if v == nil {
+				delete(secret.Data, k)
+				continue
+			}
+			secret.Data[k] = v
+		}
+		_ = json.NewEncoder(w).Encode(secret)
+
+	case r.Method == http.MethodPost && name == "":
+		secret := &kubeSecret{}
+		_ = json.Unmarshal(body, secret)
+		if fk.createdConcurrently != nil {
+			fk.secrets[secret.Metadata.Name] = fk.createdConcurrently
+			fk.createdConcurrently = nil
+		}
+		if _, exists := fk.secrets[secret.Metadata.Name]; exists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		fk.secrets[secret.Metadata.Name] = secret
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(secret)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// testKubeSecretOutput returns a fake api server and a kube secret output that uses it
+func testKubeSecretOutput(t *testing.T) (*fakeKubeAPI, *kubeSecretOutput) {
+	fake := &fakeKubeAPI{secrets: map[string]*kubeSecret{}}
+	srv := httptest.NewTLSServer(fake)
+	t.Cleanup(srv.Close)
+
+	return fake, &kubeSecretOutput{
+		client: &kubeClient{
+			server:     srv.URL,
+			httpClient: srv.Client(),
+			token:      "test-token",
+		},
+		namespace: "certs",
+		secret:    "example-tls",
+	}
+}
+
+// testKubeSecretStatus checks the output's status
+func testKubeSecretStatus(t *testing.T, kso *kubeSecretOutput, keyPem, certPem []byte, wantExists, wantCurrent bool) {
+	t.Helper()
+
+	exists, current, err := kso.status(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("status failed: %s", err)
+	}
+	if exists != wantExists || current != wantCurrent {
+		t.Fatalf("status = (%t, %t), want (%t, %t)", exists, current, wantExists, wantCurrent)
+	}
+}
+
+func TestKubeSecretOutputCreate(t *testing.T) {
+	fake, kso := testKubeSecretOutput(t)
+	keyPem, certPem := testKeyCert(t, 1)
+
+	testKubeSecretStatus(t, kso, keyPem, certPem, false, false)
+
+	err := kso.write(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	secret := fake.secrets["example-tls"]
+	if secret == nil {
+		t.Fatal("secret was not created")
+	}
+	if secret.Type != kubeSecretTypeTLS || secret.Metadata.Namespace != "certs" {
+		t.Errorf("secret type = %s, namespace = %s", secret.Type, secret.Metadata.Namespace)
+	}
+	if !bytes.Equal(secret.Data[kubeSecretCertKey], certPem) || !bytes.Equal(secret.Data[kubeSecretKeyKey], keyPem) {
+		t.Error("secret data does not match the key/cert")
+	}
+
+	testKubeSecretStatus(t, kso, keyPem, certPem, true, true)
+}
+
+func TestKubeSecretOutputUpdate(t *testing.T) {
+	fake, kso := testKubeSecretOutput(t)
+	fake.secrets["example-tls"] = &kubeSecret{
+		Metadata: kubeSecretMeta{Name: "example-tls", Namespace: "certs"},
+		Type:     kubeSecretTypeTLS,
+		Data: map[string][]byte{
+			kubeSecretCertKey: []byte("old cert"),
+			kubeSecretKeyKey:  []byte("old key"),
+			"other":           []byte("kept"),
+		},
+	}
+	keyPem, certPem := testKeyCert(t, 1)
+
+	testKubeSecretStatus(t, kso, keyPem, certPem, true, false)
+
+	err := kso.write(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	secret := fake.secrets["example-tls"]
+	if !bytes.Equal(secret.Data[kubeSecretCertKey], certPem) || !bytes.Equal(secret.Data[kubeSecretKeyKey], keyPem) {
+		t.Error("secret data was not updated")
+	}
+	if string(secret.Data["other"]) != "kept" {
+		t.Error("secret data that isn't managed was not kept")
+	}
+	if strings.Join(fake.requests, " ") != "GET PATCH" {
+		t.Errorf("requests = %v, want a single patch", fake.requests)
+	}
+
+	testKubeSecretStatus(t, kso, keyPem, certPem, true, true)
+
+	// a secret that isn't a tls secret isn't overwritten
+	fake.secrets["example-tls"].Type = "Opaque"
+	_, _, err = kso.status(context.Background(), keyPem, certPem)
+	if err == nil {
+		t.Error("status did not fail for a secret with the wrong type")
+	}
+}
+
+func TestKubeSecretOutputConflict(t *testing.T) {
+	fake, kso := testKubeSecretOutput(t)
+	fake.createdConcurrently = &kubeSecret{
+		Metadata: kubeSecretMeta{Name: "example-tls", Namespace: "certs"},
+		Type:     kubeSecretTypeTLS,
+		Data:     map[string][]byte{kubeSecretCertKey: []byte("other cert")},
+	}
+	keyPem, certPem := testKeyCert(t, 1)
+
+	err := kso.write(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	if strings.Join(fake.requests, " ") != "PATCH POST PATCH" {
+		t.Errorf("requests = %v, want patch, create (conflict), patch", fake.requests)
+	}
+	testKubeSecretStatus(t, kso, keyPem, certPem, true, true)
+}
+
+func TestKubeSecretOutputRemoveCA(t *testing.T) {
+	fake, kso := testKubeSecretOutput(t)
+	kso.includeCA = true
+	keyPem, leafPem := testKeyCert(t, 1)
+	_, caPem := testKeyCert(t, 2)
+	certPem := append(leafPem, caPem...)
+
+	err := kso.write(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	if _, exists := fake.secrets["example-tls"].Data[kubeSecretCAKey]; !exists {
+		t.Fatalf("%s was not written", kubeSecretCAKey)
+	}
+
+	// no longer included, so the existing ca.crt isn't current
+	kso.includeCA = false
+	testKubeSecretStatus(t, kso, keyPem, certPem, true, false)
+
+	err = kso.write(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	if _, exists := fake.secrets["example-tls"].Data[kubeSecretCAKey]; exists {
+		t.Errorf("%s was not removed", kubeSecretCAKey)
+	}
+
+	testKubeSecretStatus(t, kso, keyPem, certPem, true, true)
+}
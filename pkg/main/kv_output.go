@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// kvSourceKey is the key (under the prefix) that holds the hash of the key/cert the
+// values were made from; it is used to determine if the kv store is current
+const kvSourceKey = ".certwarden-source"
+
+// errKVConflict is returned by a kvStore when a transaction failed because a key was
+// modified after it was read
+var errKVConflict = errors.New("key(s) were modified by something else during the update")
+
+// kvEntry is a value in a kvStore and the revision it was last modified at
+type kvEntry struct {
+	value    []byte
+	revision int64
+}
+
+// kvStore is a key/value store that supports transactional writes
+type kvStore interface {
+	// get returns the entries for the keys that exist
+	get(ctx context.Context, keys []string) (map[string]kvEntry, error)
+	// putAll atomically writes all of the values, only if none of the keys were modified
+	// after the specified revisions (a missing revision means the key must not exist)
+	putAll(ctx context.Context, values map[string][]byte, revisions map[string]int64) error
+}
+
+// kvOutput is an output backend that writes the configured outputs under a prefix in a
+// key/value store. All of the keys are updated together in one transaction.
+type kvOutput struct {
+	storeName string
+	store     kvStore
+	prefix    string
+	// filenames of the outputs to write (see app.makeOutputContent); the key of each is
+	// the prefix + the filename
+	filenames   []string
+	makeContent func(filename string, keyPem, certPem []byte) ([]byte, error)
+	// outputConfig is the config of the outputs (see app.outputConfig)
+	outputConfig string
+}
+
+// name implements outputBackend
+func (ko *kvOutput) name() string {
+	return fmt.Sprintf("%s keys %s", ko.storeName, ko.prefix)
+}
+
+// keys returns all of the keys the output writes
+func (ko *kvOutput) keys() []string {
+	keys := []string{}
+	for _, filename := range ko.filenames {
+		keys = append(keys, ko.prefix+filename)
+	}
+	return append(keys, ko.prefix+kvSourceKey)
+}
+
+// status implements outputBackend
+func (ko *kvOutput) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	entries, err := ko.store.get(ctx, ko.keys())
+	if err != nil {
+		return false, false, err
+	}
+
+	for _, filename := range ko.filenames {
+		if _, ok := entries[ko.prefix+filename]; !ok {
+			return false, false, nil
+		}
+	}
+
+	return true, string(entries[ko.prefix+kvSourceKey].value) == outputSourceHash(keyPem, certPem, ko.outputConfig), nil
+}
+
+// write implements outputBackend
+func (ko *kvOutput) write(ctx context.Context, keyPem, certPem []byte) error {
+	values := map[string][]byte{
+		ko.prefix + kvSourceKey: []byte(outputSourceHash(keyPem, certPem, ko.outputConfig)),
+	}
+	for _, filename := range ko.filenames {
+		data, err := ko.makeContent(filename, keyPem, certPem)
+		if err != nil {
+			return fmt.Errorf("failed to make %s (%s)", filename, err)
+		}
+		values[ko.prefix+filename] = data
+	}
+
+	// current revisions, the write fails if anything changes after this
+	entries, err := ko.store.get(ctx, ko.keys())
+	if err != nil {
+		return err
+	}
+	revisions := map[string]int64{}
+	for key, entry := range entries {
+		revisions[key] = entry.revision
+	}
+
+	return ko.store.putAll(ctx, values, revisions)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"time"
 )
 
@@ -17,6 +18,16 @@ func main() {
 		// os.Exit(1)
 	}
 
+	// if a command was specified, run it instead of the client
+	if len(os.Args) > 1 {
+		err = app.runCommand(os.Args[1:])
+		if err != nil {
+			app.logger.Fatalf("%s command failed (%s)", os.Args[1], err)
+			// os.Exit(1)
+		}
+		return
+	}
+
 	// try and get newer key/cert from server on start
 	currentCertInMemory := false
 	err = app.updateClientKeyAndCertchain()
@@ -47,6 +58,25 @@ func main() {
 		app.scheduleJobFetchCertsAndWriteToDisk()
 	}
 
+	// start ocsp staple file refresher
+	if app.cfg.OCSPStapleCreate {
+		app.startOCSPStapleFileRefresher()
+	}
+
+	// start https server ocsp stapling
+	if app.cfg.OCSPHttpsStapling {
+		app.startHttpsOCSPStapler()
+	}
+
+	// start envoy sds server
+	if app.cfg.SDSListen != "" {
+		err = app.startSDSServer()
+		if err != nil {
+			app.logger.Fatalf("could not start sds server (%s)", err)
+			// os.Exit(1)
+		}
+	}
+
 	// start https server
 	err = app.startHttpsServer()
 	if err != nil {
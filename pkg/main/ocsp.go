@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspMaxResponseSize is the max size of an ocsp response that will be read
+const ocspMaxResponseSize = 1 << 20
+
+// ocspRetryInterval is how long to wait before trying again after an ocsp fetch fails
+const ocspRetryInterval = 15 * time.Minute
+
+// ocspStapleCerts returns the leaf and issuer from certPem, as needed to request and
+// validate an ocsp response
+func ocspStapleCerts(certPem []byte) (leaf, issuer *x509.Certificate, err error) {
+	leaf, chain, err := certPemToCerts(certPem)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(chain) == 0 {
+		return nil, nil, errors.New("cert pem does not contain the issuer cert")
+	}
+
+	return leaf, chain[0], nil
+}
+
+// validateOCSPResponse parses and validates the raw ocsp response for the leaf/issuer;
+// an error is returned unless the response is correctly signed, Good, for the leaf's
+// serial, and current
+func validateOCSPResponse(raw []byte, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	// parse (this also verifies the signature)
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ocsp response (%s)", err)
+	}
+
+	if resp.SerialNumber == nil || resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return nil, errors.New("ocsp response serial does not match cert")
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		// ok
+	case ocsp.Revoked:
+		return nil, fmt.Errorf("ocsp responder says cert is revoked (at %s)", resp.RevokedAt)
+	default:
+		return nil, errors.New("ocsp responder says cert status is unknown")
+	}
+
+	now := time.Now()
+	// allow some clock skew on this update
+	if resp.ThisUpdate.After(now.Add(5 * time.Minute)) {
+		return nil, fmt.Errorf("ocsp response is not valid yet (this update %s)", resp.ThisUpdate)
+	}
+	if !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+		return nil, fmt.Errorf("ocsp response is stale (next update %s)", resp.NextUpdate)
+	}
+
+	return resp, nil
+}
+
+// ocspRefreshTime returns when a new ocsp response should be fetched to replace resp;
+// this is half way through the response's validity period
+func ocspRefreshTime(resp *ocsp.Response) time.Time {
+	// no next update means newer info is always available, refresh on the retry interval
+	if resp.NextUpdate.IsZero() {
+		return resp.ThisUpdate.Add(ocspRetryInterval)
+	}
+
+	return resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+}
+
+// fetchOCSPResponse requests an ocsp response for the cert in certPem and validates it.
+// If responderURL is blank, the responder from the cert's AIA is used.
+func (app *app) fetchOCSPResponse(ctx context.Context, certPem []byte, responderURL string) (raw []byte, resp *ocsp.Response, err error) {
+	leaf, issuer, err := ocspStapleCerts(certPem)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// responder
+	if responderURL == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return nil, nil, errors.New("cert does not specify an ocsp responder")
+		}
+		responderURL = leaf.OCSPServer[0]
+	}
+
+	// make request
+	reqDer, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create ocsp request (%s)", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responderURL, bytes.NewReader(reqDer))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	// do the request
+	httpResp, err := app.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+
+	// read body (before err check to ensure body is always read completely)
+	raw, err = io.ReadAll(io.LimitReader(httpResp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// error if not code 200
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("error fetching ocsp response from %s (status: %d)", responderURL, httpResp.StatusCode)
+	}
+
+	// validate
+	resp, err = validateOCSPResponse(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return raw, resp, nil
+}
+
+// startOCSPRefresher runs refresh in the background until shutdown. refresh is run
+// again at the time it returns, or sooner if a refresh is requested on refreshNow
+// (see requestOCSPRefresh).
+func (app *app) startOCSPRefresher(name string, refreshNow chan struct{}, refresh func() (nextRefresh time.Time)) {
+	app.shutdownWaitgroup.Add(1)
+	go func() {
+		defer app.shutdownWaitgroup.Done()
+
+		for {
+			nextRefresh := refresh()
+			app.logger.Debugf("next %s refresh scheduled for %s", name, nextRefresh.Round(time.Second))
+
+			select {
+			case <-app.shutdownContext.Done():
+				app.logger.Infof("%s refresher shutdown complete", name)
+				return
+
+			case <-refreshNow:
+				// refresh requested (e.g. new cert)
+
+			case <-time.After(time.Until(nextRefresh)):
+				// time to refresh
+			}
+		}
+	}()
+}
+
+// requestOCSPRefresh triggers an immediate refresh of an ocsp refresher (if it is
+// running)
+func requestOCSPRefresh(refreshNow chan struct{}) {
+	if refreshNow == nil {
+		return
+	}
+
+	// don't block if a refresh is already pending
+	select {
+	case refreshNow <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// startHttpsOCSPStapler starts a background job that keeps an ocsp response stapled
+// to the client's own https server certificate. A staple that fails to refresh is
+// dropped once it goes stale (see SafeCert.TlsCertFunc).
+func (app *app) startHttpsOCSPStapler() {
+	app.startOCSPRefresher("https server ocsp staple", app.httpsOCSPStapleRefresh, app.refreshHttpsOCSPStaple)
+}
+
+// refreshHttpsOCSPStaple updates the https server's ocsp staple (if needed) and returns
+// when it should be refreshed next
+func (app *app) refreshHttpsOCSPStaple() (nextRefresh time.Time) {
+	// if current staple is not due for refresh, nothing to do
+	refreshTime := app.tlsCert.OCSPStapleRefreshTime()
+	if !refreshTime.IsZero() && time.Now().Before(refreshTime) {
+		return refreshTime
+	}
+
+	_, certPem := app.tlsCert.Read()
+	if len(certPem) == 0 {
+		return time.Now().Add(ocspRetryInterval)
+	}
+
+	// fetch new response
+	ctx, cancel := context.WithTimeout(app.shutdownContext, 1*time.Minute)
+	defer cancel()
+
+	raw, resp, err := app.fetchOCSPResponse(ctx, certPem, app.cfg.OCSPResponderURL)
+	if err != nil {
+		app.logger.Errorf("https server ocsp staple: failed to fetch ocsp response (%s), will try again later", err)
+		return time.Now().Add(ocspRetryInterval)
+	}
+
+	// if the responder sent an older (cached) response that is already due for refresh,
+	// don't spin; try again after the retry interval
+	nextRefresh = ocspRefreshTime(resp)
+	if nextRefresh.Before(time.Now()) {
+		nextRefresh = time.Now().Add(ocspRetryInterval)
+	}
+
+	if app.tlsCert.SetOCSPStaple(certPem, raw, resp, nextRefresh) {
+		app.logger.Infof("https server ocsp staple updated (ocsp response valid until %s)", resp.NextUpdate)
+	} else {
+		// cert changed during fetch, go again for the new cert
+		app.logger.Debug("https server ocsp staple: cert changed while fetching ocsp response, discarding response")
+		return time.Now()
+	}
+
+	return nextRefresh
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// startOCSPStapleFileRefresher starts a background job that keeps a DER ocsp response
+// for the cert on disk in the staple file (e.g. for nginx ssl_stapling_file or
+// haproxy .ocsp). The response is refreshed half way through its validity and when
+// new cert files are written. The staple file is not part of the managed file set
+// (it is not archived or versioned) since it changes independently of the cert.
+func (app *app) startOCSPStapleFileRefresher() {
+	app.startOCSPRefresher("ocsp staple file", app.ocspStapleFileRefresh, app.refreshOCSPStapleFile)
+}
+
+// refreshOCSPStapleFile updates the staple file (if needed) and returns when it should
+// be refreshed next
+func (app *app) refreshOCSPStapleFile() (nextRefresh time.Time) {
+	stapleFilePath := filepath.Join(app.cfg.CertStoragePath, app.cfg.OCSPStapleFilename)
+
+	// staple is for the cert that is on disk (which may differ from the one in memory
+	// if the file write hasn't happened yet)
+	certPem, _, err := readManagedFile(app.cfg.CertStoragePath, "certchain.pem")
+	if err != nil {
+		app.logger.Debugf("ocsp staple file: cannot read certchain.pem (%s), will try again later", err)
+		return time.Now().Add(ocspRetryInterval)
+	}
+
+	leaf, issuer, err := ocspStapleCerts(certPem)
+	if err != nil {
+		app.logger.Errorf("ocsp staple file: cannot use certchain.pem (%s)", err)
+		return time.Now().Add(ocspRetryInterval)
+	}
+
+	// if existing staple is still good and not due for refresh, nothing to do
+	existingStaple, err := os.ReadFile(stapleFilePath)
+	if err == nil {
+		resp, err := validateOCSPResponse(existingStaple, leaf, issuer)
+		if err == nil && time.Now().Before(ocspRefreshTime(resp)) {
+			return ocspRefreshTime(resp)
+		}
+	}
+
+	// fetch new response
+	ctx, cancel := context.WithTimeout(app.shutdownContext, 1*time.Minute)
+	defer cancel()
+
+	raw, resp, err := app.fetchOCSPResponse(ctx, certPem, app.cfg.OCSPResponderURL)
+	if err != nil {
+		app.logger.Errorf("ocsp staple file: failed to fetch ocsp response (%s), will try again later", err)
+		return time.Now().Add(ocspRetryInterval)
+	}
+
+	// write if changed
+	if !bytes.Equal(existingStaple, raw) {
+		err = writeFileAtomic(stapleFilePath, raw, app.cfg.CertPermissions)
+		if err != nil {
+			app.logger.Errorf("ocsp staple file: failed to write %s (%s)", app.cfg.OCSPStapleFilename, err)
+			return time.Now().Add(ocspRetryInterval)
+		}
+		app.logger.Infof("wrote new %s file (ocsp response valid until %s)", app.cfg.OCSPStapleFilename, resp.NextUpdate)
+
+		// only restart containers for a staple update if configured to
+		if app.cfg.OCSPStapleRestartContainers && app.dockerUpdatesConfigured() {
+			app.logger.Info("ocsp staple file changed, updating docker containers")
+			app.restartOrStopDockerContainers()
+		}
+	}
+
+	// if the responder sent an older (cached) response that is already due for refresh,
+	// don't spin; try again after the retry interval
+	nextRefresh = ocspRefreshTime(resp)
+	if nextRefresh.Before(time.Now()) {
+		nextRefresh = time.Now().Add(ocspRetryInterval)
+	}
+
+	return nextRefresh
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPIssuer is a CA that issues certs and signs ocsp responses for them
+type testOCSPIssuer struct {
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	certPem []byte
+}
+
+// newTestOCSPIssuer returns a new self signed CA
+func newTestOCSPIssuer(t *testing.T) *testOCSPIssuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1000),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testOCSPIssuer{
+		key:     key,
+		cert:    cert,
+		certPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a new leaf cert with the specified serial and the cert pem of the leaf
+// followed by the issuer
+func (ti *testOCSPIssuer) issue(t *testing.T, serial int64) (leaf *x509.Certificate, certPem []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ti.cert, &key.PublicKey, ti.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf, append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), ti.certPem...)
+}
+
+// respond returns an ocsp response signed by the issuer
+func (ti *testOCSPIssuer) respond(t *testing.T, template ocsp.Response) []byte {
+	t.Helper()
+
+	raw, err := ocsp.CreateResponse(ti.cert, ti.cert, template, ti.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+// testOCSPResponder is an ocsp responder that says every cert of the issuer is good
+type testOCSPResponder struct {
+	mu       sync.Mutex
+	issuer   *testOCSPIssuer
+	t        *testing.T
+	requests int
+}
+
+func (tr *testOCSPResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.requests++
+
+	body, _ := io.ReadAll(r.Body)
+	req, err := ocsp.ParseRequest(body)
+	if err != nil || r.Header.Get("Content-Type") != "application/ocsp-request" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	_, _ = w.Write(tr.issuer.respond(tr.t, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now.Add(-1 * time.Minute),
+		NextUpdate:   now.Add(4 * 24 * time.Hour),
+	}))
+}
+
+// requestCount returns the number of requests the responder received
+func (tr *testOCSPResponder) requestCount() int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return tr.requests
+}
+
+func TestValidateOCSPResponse(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	leaf, _ := issuer.issue(t, 1)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		template ocsp.Response
+		valid    bool
+	}{
+		{"good", ocsp.Response{Status: ocsp.Good, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(-1 * time.Hour), NextUpdate: now.Add(1 * time.Hour)}, true},
+		{"good without next update", ocsp.Response{Status: ocsp.Good, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(-1 * time.Hour)}, true},
+		{"revoked", ocsp.Response{Status: ocsp.Revoked, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(-1 * time.Hour), NextUpdate: now.Add(1 * time.Hour), RevokedAt: now.Add(-2 * time.Hour)}, false},
+		{"unknown", ocsp.Response{Status: ocsp.Unknown, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(-1 * time.Hour), NextUpdate: now.Add(1 * time.Hour)}, false},
+		{"wrong serial", ocsp.Response{Status: ocsp.Good, SerialNumber: big.NewInt(2), ThisUpdate: now.Add(-1 * time.Hour), NextUpdate: now.Add(1 * time.Hour)}, false},
+		{"stale", ocsp.Response{Status: ocsp.Good, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(-2 * time.Hour), NextUpdate: now.Add(-1 * time.Hour)}, false},
+		{"future this update", ocsp.Response{Status: ocsp.Good, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(1 * time.Hour), NextUpdate: now.Add(2 * time.Hour)}, false},
+		{"small clock skew", ocsp.Response{Status: ocsp.Good, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(1 * time.Minute), NextUpdate: now.Add(1 * time.Hour)}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := validateOCSPResponse(issuer.respond(t, test.template), leaf, issuer.cert)
+			if test.valid && err != nil {
+				t.Errorf("response is not valid (%s)", err)
+			} else if !test.valid && err == nil {
+				t.Error("response is valid")
+			}
+		})
+	}
+
+	// signed by a different issuer
+	otherIssuer := newTestOCSPIssuer(t)
+	raw := otherIssuer.respond(t, ocsp.Response{Status: ocsp.Good, SerialNumber: leaf.SerialNumber, ThisUpdate: now.Add(-1 * time.Hour), NextUpdate: now.Add(1 * time.Hour)})
+	_, err := validateOCSPResponse(raw, leaf, issuer.cert)
+	if err == nil {
+		t.Error("response signed by another issuer is valid")
+	}
+}
+
+func TestOCSPRefreshTime(t *testing.T) {
+	thisUpdate := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	refresh := ocspRefreshTime(&ocsp.Response{ThisUpdate: thisUpdate, NextUpdate: thisUpdate.Add(4 * 24 * time.Hour)})
+	if want := thisUpdate.Add(2 * 24 * time.Hour); !refresh.Equal(want) {
+		t.Errorf("refresh time = %s, want %s", refresh, want)
+	}
+
+	// no next update
+	refresh = ocspRefreshTime(&ocsp.Response{ThisUpdate: thisUpdate})
+	if want := thisUpdate.Add(ocspRetryInterval); !refresh.Equal(want) {
+		t.Errorf("refresh time = %s, want %s", refresh, want)
+	}
+}
+
+func TestRefreshOCSPStapleFile(t *testing.T) {
+	issuer := newTestOCSPIssuer(t)
+	leaf, certPem := issuer.issue(t, 1)
+
+	responder := &testOCSPResponder{issuer: issuer, t: t}
+	srv := httptest.NewServer(responder)
+	t.Cleanup(srv.Close)
+
+	app := testApp(t)
+	app.httpClient = srv.Client()
+	app.cfg.OCSPResponderURL = srv.URL
+	app.cfg.OCSPStapleFilename = "certchain.pem.ocsp"
+	app.cfg.DockerContainersToRestart = []dockerContainer{{Name: "nginx"}}
+	fakeDocker := testFakeDocker(t, app, "nginx")
+
+	err := os.WriteFile(filepath.Join(app.cfg.CertStoragePath, "certchain.pem"), certPem, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stapleFilePath := filepath.Join(app.cfg.CertStoragePath, app.cfg.OCSPStapleFilename)
+
+	// writes the staple
+	nextRefresh := app.refreshOCSPStapleFile()
+	staple, err := os.ReadFile(stapleFilePath)
+	if err != nil {
+		t.Fatalf("staple file was not written (%s)", err)
+	}
+	resp, err := validateOCSPResponse(staple, leaf, issuer.cert)
+	if err != nil {
+		t.Fatalf("staple file is not valid (%s)", err)
+	}
+	if !nextRefresh.Equal(ocspRefreshTime(resp)) {
+		t.Errorf("next refresh = %s, want %s", nextRefresh, ocspRefreshTime(resp))
+	}
+	if responder.requestCount() != 1 {
+		t.Errorf("responder got %d requests, want 1", responder.requestCount())
+	}
+
+	// skips while the existing staple is fresh
+	nextRefresh = app.refreshOCSPStapleFile()
+	if responder.requestCount() != 1 {
+		t.Errorf("responder got %d requests, want 1 (staple is fresh)", responder.requestCount())
+	}
+	if !nextRefresh.Equal(ocspRefreshTime(resp)) {
+		t.Errorf("next refresh = %s, want %s", nextRefresh, ocspRefreshTime(resp))
+	}
+	unchangedStaple, _ := os.ReadFile(stapleFilePath)
+	if !bytes.Equal(unchangedStaple, staple) {
+		t.Error("fresh staple file was replaced")
+	}
+
+	if actions := fakeDocker.actionsString(); actions != "" {
+		t.Errorf("containers were updated (%s) without OCSPStapleRestartContainers", actions)
+	}
+
+	// restarts containers when configured to
+	app.cfg.OCSPStapleRestartContainers = true
+	err = os.Remove(stapleFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = app.refreshOCSPStapleFile()
+	if responder.requestCount() != 2 {
+		t.Errorf("responder got %d requests, want 2", responder.requestCount())
+	}
+	if actions := fakeDocker.actionsString(); actions != "restart nginx" {
+		t.Errorf("container actions = \"%s\", want \"restart nginx\"", actions)
+	}
+}
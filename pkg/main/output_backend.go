@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// outputBackendTimeout is the max time an output backend status check or write may take
+const outputBackendTimeout = 1 * time.Minute
+
+// outputBackend is a destination, other than the cert storage path, that the key/cert
+// are written to (e.g. a kubernetes secret). Backends follow the same missing/updated
+// and update window logic as the files in the cert storage path.
+type outputBackend interface {
+	// name describes the backend (for logging)
+	name() string
+	// status returns if the destination exists and, if it does, if its content is
+	// current for keyPem and certPem
+	status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error)
+	// write writes the key/cert to the destination
+	write(ctx context.Context, keyPem, certPem []byte) error
+}
+
+// makeTLSHttpClient returns an http.Client for an output backend's api using the
+// specified tls config
+func makeTLSHttpClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+}
+
+// makeOutputBackendHttpClient returns an http.Client for an output backend's api. If
+// caFile is specified, the certs in it are trusted instead of the system's.
+func makeOutputBackendHttpClient(caFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if caFile != "" {
+		caPem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file (%s)", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, errors.New("ca file does not contain any valid certificates")
+		}
+	}
+
+	return makeTLSHttpClient(tlsConfig), nil
+}
+
+// outputSourceHash returns a hash of the key/cert and the config of the outputs (see
+// app.outputConfig) that a backend can store with its outputs to determine if they are
+// current (this is needed since some outputs, such as pfx, differ every time they're
+// made)
+func outputSourceHash(keyPem, certPem []byte, outputConfig string) string {
+	h := sha256.New()
+	h.Write(keyPem)
+	h.Write(certPem)
+	h.Write([]byte(outputConfig))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// outputFile is an additional file (beyond key.pem and certchain.pem) that is made
+// from the key and cert pem and written to the cert storage path
+type outputFile struct {
+	filename string
+	perm     fs.FileMode
+	// description is used for logging
+	description string
+	// make returns the file's content
+	make func(keyPem, certPem []byte) ([]byte, error)
+	// config describes the options make uses, so output backends can detect a config
+	// change (see app.outputConfig)
+	config string
+	// compareContent should be true if make's output is deterministic; the file on disk
+	// is then compared to the made content to determine if it needs an update. If false
+	// (e.g. content has a random salt), key.pem or certchain.pem being updated is used as
+	// a proxy for this file needing an update.
+	compareContent bool
+}
+
+// additionalOutputFiles returns all of the additional output files that are enabled in
+// the config
+func (app *app) additionalOutputFiles() []outputFile {
+	outputs := []outputFile{}
+
+	if app.cfg.PfxCreate {
+		outputs = append(outputs, outputFile{
+			filename:    app.cfg.PfxFilename,
+			perm:        app.cfg.KeyPermissions,
+			description: "modern pfx",
+			make: func(keyPem, certPem []byte) ([]byte, error) {
+				return makePfx(keyPem, certPem, pfxOptions{Encoding: "modern", Password: app.cfg.PfxPassword})
+			},
+			config: fmt.Sprintf("%+v", pfxOptions{Encoding: "modern", Password: app.cfg.PfxPassword}),
+		})
+	}
+
+	if app.cfg.PfxLegacyCreate {
+		outputs = append(outputs, outputFile{
+			filename:    app.cfg.PfxLegacyFilename,
+			perm:        app.cfg.KeyPermissions,
+			description: "legacy pfx",
+			make: func(keyPem, certPem []byte) ([]byte, error) {
+				return makePfx(keyPem, certPem, pfxOptions{Encoding: "legacy", Password: app.cfg.PfxLegacyPassword})
+			},
+			config: fmt.Sprintf("%+v", pfxOptions{Encoding: "legacy", Password: app.cfg.PfxLegacyPassword}),
+		})
+	}
+
+	for _, pfxOutput := range app.cfg.PfxOutputs {
+		// ca only doesn't contain the key
+		perm := app.cfg.KeyPermissions
+		description := pfxOutput.Options.Encoding + " pfx"
+		if pfxOutput.Options.CAOnly {
+			perm = app.cfg.CertPermissions
+			description = pfxOutput.Options.Encoding + " ca only pfx"
+		}
+
+		outputs = append(outputs, outputFile{
+			filename:    pfxOutput.Filename,
+			perm:        perm,
+			description: description,
+			make: func(keyPem, certPem []byte) ([]byte, error) {
+				return makePfx(keyPem, certPem, pfxOutput.Options)
+			},
+			config: fmt.Sprintf("%+v", pfxOutput.Options),
+		})
+	}
+
+	if app.cfg.KeyEncryptedCreate {
+		outputs = append(outputs, outputFile{
+			filename:    app.cfg.KeyEncryptedFilename,
+			perm:        app.cfg.KeyPermissions,
+			description: "encrypted key",
+			make: func(keyPem, _ []byte) ([]byte, error) {
+				return makeEncryptedKeyPem(keyPem, app.cfg.KeyEncryptedPassword, app.cfg.KeyEncryptedOpts)
+			},
+			config: fmt.Sprintf("%s %s %+v", app.cfg.KeyEncryptedPassword, app.cfg.KeyEncryptedOpts.Cipher.OID(), app.cfg.KeyEncryptedOpts.KDFOpts),
+		})
+	}
+
+	if app.cfg.CABundleCreate {
+		outputs = append(outputs, outputFile{
+			filename:    app.cfg.CABundleFilename,
+			perm:        app.cfg.CertPermissions,
+			description: "ca bundle",
+			make: func(_, certPem []byte) ([]byte, error) {
+				return makeCABundle(certPem, app.cfg.CABundleRootPem, app.cfg.CABundleRootFromSystem)
+			},
+			config:         fmt.Sprintf("%s %t", app.cfg.CABundleRootPem, app.cfg.CABundleRootFromSystem),
+			compareContent: true,
+		})
+	}
+
+	if app.cfg.TraefikConfigCreate {
+		outputs = append(outputs, outputFile{
+			filename:    app.cfg.TraefikConfigFilename,
+			perm:        app.cfg.CertPermissions,
+			description: "traefik config",
+			make: func(_, certPem []byte) ([]byte, error) {
+				return makeTraefikConfig(certPem, app.cfg.TraefikConfigOptions)
+			},
+			config:         fmt.Sprintf("%+v", app.cfg.TraefikConfigOptions),
+			compareContent: true,
+		})
+	}
+
+	if app.cfg.CombinedPemCreate {
+		outputs = append(outputs, outputFile{
+			filename:    app.cfg.CombinedPemFilename,
+			perm:        app.cfg.KeyPermissions,
+			description: "combined pem",
+			make: func(keyPem, certPem []byte) ([]byte, error) {
+				keyPem, err := convertKeyPem(keyPem, app.cfg.KeyFormat)
+				if err != nil {
+					return nil, err
+				}
+				return makeCombinedPem(keyPem, certPem), nil
+			},
+			config:         app.cfg.KeyFormat,
+			compareContent: true,
+		})
+	}
+
+	for _, keyOutput := range app.cfg.KeyOutputs {
+		outputs = append(outputs, outputFile{
+			filename:    keyOutput.Filename,
+			perm:        app.cfg.KeyPermissions,
+			description: keyOutput.Format + " key",
+			make: func(keyPem, _ []byte) ([]byte, error) {
+				return convertKeyPem(keyPem, keyOutput.Format)
+			},
+			config:         keyOutput.Format,
+			compareContent: true,
+		})
+	}
+
+	return outputs
+}
+
+// makeOutputContent returns the content and permissions of the named output (key.pem,
+// certchain.pem, or one of the additional output files) for the key/cert, as it would be
+// written to the cert storage path. This lets other output backends write the same
+// artifacts.
+func (app *app) makeOutputContent(filename string, keyPem, certPem []byte) (data []byte, perm fs.FileMode, err error) {
+	switch filename {
+	case "key.pem":
+		data, err = convertKeyPem(keyPem, app.cfg.KeyFormat)
+		return data, app.cfg.KeyPermissions, err
+
+	case "certchain.pem":
+		return certPem, app.cfg.CertPermissions, nil
+
+	default:
+		// fallthrough
+	}
+
+	for _, output := range app.additionalOutputFiles() {
+		if output.filename == filename {
+			data, err = output.make(keyPem, certPem)
+			return data, output.perm, err
+		}
+	}
+
+	return nil, 0, fmt.Errorf("%s is not a configured output", filename)
+}
+
+// outputConfig returns a description of the config the named outputs are made with
+// (format, encoding, permissions, etc.). Output backends include it in their source
+// hash so that a config change is written, even if the key/cert didn't change.
+func (app *app) outputConfig(filenames []string) string {
+	config := ""
+	for _, filename := range filenames {
+		switch filename {
+		case "key.pem":
+			config += fmt.Sprintf("%s %o %s\n", filename, app.cfg.KeyPermissions, app.cfg.KeyFormat)
+			continue
+
+		case "certchain.pem":
+			config += fmt.Sprintf("%s %o\n", filename, app.cfg.CertPermissions)
+			continue
+
+		default:
+			// fallthrough
+		}
+
+		for _, output := range app.additionalOutputFiles() {
+			if output.filename == filename {
+				config += fmt.Sprintf("%s %o %s\n", filename, output.perm, output.config)
+			}
+		}
+	}
+
+	return config
+}
+
+// isOutputFilename returns true if filename is key.pem, certchain.pem, or one of the
+// configured additional output files
+func (app *app) isOutputFilename(filename string) bool {
+	for _, name := range app.managedFilenames() {
+		if name == filename {
+			return true
+		}
+	}
+
+	return false
+}
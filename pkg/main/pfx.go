@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 
 	"software.sslmate.com/src/go-pkcs12"
 )
@@ -97,12 +98,35 @@ func certPemToCerts(certPem []byte) (cert *x509.Certificate, certChain []*x509.C
 	return cert, certChain, nil
 }
 
-// makeModernPfx returns the pkcs12 pfx data for the given key and cert pem
-func makeModernPfx(keyPem, certPem []byte, password string) (pfxData []byte, err error) {
-	// get private key
-	key, err := keyPemToKey(keyPem)
-	if err != nil {
-		return nil, err
+// pfxEncoders maps config encoding names to the pkcs12 encoder
+var pfxEncoders = map[string]*pkcs12.Encoder{
+	"modern":       pkcs12.Modern2023,
+	"modern2023":   pkcs12.Modern2023,
+	"modern2026":   pkcs12.Modern2026,
+	"legacy":       pkcs12.LegacyDES,
+	"legacy-des":   pkcs12.LegacyDES,
+	"legacy-rc2":   pkcs12.LegacyRC2,
+	"passwordless": pkcs12.Passwordless,
+}
+
+// pfxOptions are the options used to encode a pfx
+type pfxOptions struct {
+	// Encoding is a key of pfxEncoders
+	Encoding string
+	Password string
+	// FriendlyName is the friendly name (alias) of the certs of a CA only pfx, if blank
+	// each cert's subject is used
+	FriendlyName string
+	// CAOnly creates a trust store containing only the chain (no key or leaf cert)
+	CAOnly bool
+}
+
+// makePfx returns the pkcs12 pfx data for the given key and cert pem, encoded using
+// the specified options
+func makePfx(keyPem, certPem []byte, opts pfxOptions) (pfxData []byte, err error) {
+	encoder, ok := pfxEncoders[opts.Encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported pfx encoding \"%s\"", opts.Encoding)
 	}
 
 	// get cert and chain (if there is a chain)
@@ -111,36 +135,39 @@ func makeModernPfx(keyPem, certPem []byte, password string) (pfxData []byte, err
 		return nil, err
 	}
 
-	// encode using modern pkcs12 standard
-	pfxData, err = pkcs12.Modern.Encode(key, cert, certChain, password)
-	if err != nil {
-		return nil, err
-	}
+	// CA only (trust store)
+	if opts.CAOnly {
+		if len(certChain) == 0 {
+			return nil, errors.New("cert pem does not contain a chain for a ca only pfx")
+		}
 
-	return pfxData, nil
-}
+		// default friendly names (cert subjects)
+		if opts.FriendlyName == "" {
+			return encoder.EncodeTrustStore(certChain, opts.Password)
+		}
 
-// makeLegacyPfx returns the pkcs12 pfx data for the given key and cert pem but in a
-// legacy format that should be avoided unless the application only supports the legacy
-// format
-func makeLegacyPfx(keyPem, certPem []byte, password string) (pfxData []byte, err error) {
-	// get private key
-	key, err := keyPemToKey(keyPem)
-	if err != nil {
-		return nil, err
+		// custom friendly names (numbered if more than one cert)
+		entries := []pkcs12.TrustStoreEntry{}
+		for i := range certChain {
+			name := opts.FriendlyName
+			if len(certChain) > 1 {
+				name = fmt.Sprintf("%s-%d", opts.FriendlyName, i+1)
+			}
+			entries = append(entries, pkcs12.TrustStoreEntry{Cert: certChain[i], FriendlyName: name})
+		}
+		return encoder.EncodeTrustStoreEntries(entries, opts.Password)
 	}
 
-	// get cert and chain (if there is a chain)
-	cert, certChain, err := certPemToCerts(certPem)
-	if err != nil {
-		return nil, err
+	// the pkcs12 lib can't set a friendly name on a key/cert
+	if opts.FriendlyName != "" {
+		return nil, errors.New("friendly name is only supported for a ca only pfx")
 	}
 
-	// encode using modern pkcs12 standard
-	pfxData, err = pkcs12.Legacy.Encode(key, cert, certChain, password)
+	// get private key
+	key, err := keyPemToKey(keyPem)
 	if err != nil {
 		return nil, err
 	}
 
-	return pfxData, nil
+	return encoder.Encode(key, cert, certChain, opts.Password)
 }
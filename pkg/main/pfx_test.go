@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"unicode/utf16"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testPfxEncodings returns the pfx encoding names, sorted
+func testPfxEncodings() []string {
+	encodings := []string{}
+	for encoding := range pfxEncoders {
+		encodings = append(encodings, encoding)
+	}
+	sort.Strings(encodings)
+
+	return encodings
+}
+
+// testPfxPassword returns the password to use for a pfx encoding
+func testPfxPassword(encoding string) string {
+	if encoding == "passwordless" {
+		return ""
+	}
+	return "p@ssword"
+}
+
+// testBMPString returns s encoded as a BMPString (UTF-16 big endian), which is how
+// pkcs12 stores friendly names
+func testBMPString(s string) []byte {
+	b := []byte{}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
+
+func TestMakePfx(t *testing.T) {
+	keyPem, leafPem := testKeyCert(t, 1)
+	_, chainPem := testKeyCert(t, 2)
+	certPem := append(append([]byte{}, leafPem...), chainPem...)
+	cert, certChain, err := certPemToCerts(certPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range testPfxEncodings() {
+		t.Run(encoding, func(t *testing.T) {
+			password := testPfxPassword(encoding)
+
+			pfxData, err := makePfx(keyPem, certPem, pfxOptions{Encoding: encoding, Password: password})
+			if err != nil {
+				t.Fatalf("makePfx failed: %s", err)
+			}
+
+			_, decodedCert, decodedChain, err := pkcs12.DecodeChain(pfxData, password)
+			if err != nil {
+				t.Fatalf("failed to decode pfx: %s", err)
+			}
+			if !decodedCert.Equal(cert) || len(decodedChain) != 1 || !decodedChain[0].Equal(certChain[0]) {
+				t.Error("decoded certs do not match")
+			}
+
+			// the pkcs12 lib can't set a key/cert friendly name
+			_, err = makePfx(keyPem, certPem, pfxOptions{Encoding: encoding, Password: password, FriendlyName: "example"})
+			if err == nil {
+				t.Error("makePfx did not fail for a key/cert friendly name")
+			}
+		})
+	}
+}
+
+func TestMakePfxCAOnly(t *testing.T) {
+	_, leafPem := testKeyCert(t, 1)
+	_, chain1Pem := testKeyCert(t, 2)
+	_, chain2Pem := testKeyCert(t, 3)
+	certPem := bytes.Join([][]byte{leafPem, chain1Pem, chain2Pem}, nil)
+	_, certChain, err := certPemToCerts(certPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range testPfxEncodings() {
+		t.Run(encoding, func(t *testing.T) {
+			password := testPfxPassword(encoding)
+
+			pfxData, err := makePfx(nil, certPem, pfxOptions{Encoding: encoding, Password: password, FriendlyName: "example", CAOnly: true})
+			if err != nil {
+				t.Fatalf("makePfx failed: %s", err)
+			}
+
+			certs, err := pkcs12.DecodeTrustStore(pfxData, password)
+			if err != nil {
+				t.Fatalf("failed to decode trust store: %s", err)
+			}
+			if len(certs) != len(certChain) {
+				t.Fatalf("trust store has %d certs, want %d", len(certs), len(certChain))
+			}
+			for i := range certs {
+				if !certs[i].Equal(certChain[i]) {
+					t.Errorf("trust store cert %d does not match the chain", i)
+				}
+			}
+
+			// names are only readable without encryption
+			if encoding == "passwordless" {
+				for _, name := range []string{"example-1", "example-2"} {
+					if !bytes.Contains(pfxData, testBMPString(name)) {
+						t.Errorf("trust store does not contain friendly name %s", name)
+					}
+				}
+			}
+		})
+	}
+
+	// a leaf without a chain can't be a trust store
+	_, err = makePfx(nil, leafPem, pfxOptions{Encoding: "modern", CAOnly: true})
+	if err == nil {
+		t.Error("makePfx did not fail for a cert without a chain")
+	}
+}
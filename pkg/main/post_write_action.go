@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// postWriteActionTimeout is the max time a post write action may take
+const postWriteActionTimeout = 3 * time.Minute
+
+// postWriteAction is run after new key/cert files are written to the cert storage path
+// (e.g. to tell a service to load the new cert without a restart)
+type postWriteAction interface {
+	// name describes the action (for logging)
+	name() string
+	// run does the action for the key/cert that was written
+	run(ctx context.Context, keyPem, certPem []byte) error
+}
+
+// runPostWriteActions runs each of the configured post write actions, in order, and
+// logs the results
+func (app *app) runPostWriteActions(keyPem, certPem []byte) {
+	for _, action := range app.postWriteActions {
+		ctx, cancel := context.WithTimeout(context.Background(), postWriteActionTimeout)
+		err := action.run(ctx, keyPem, certPem)
+		cancel()
+
+		if err != nil {
+			app.logger.Errorf("post write action %s failed (%s)", action.name(), err)
+		} else {
+			app.logger.Infof("post write action %s succeeded", action.name())
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processSignalAction is a post write action that sends a signal to a process (e.g.
+// SIGHUP to have a daemon reload its cert). The process is found by pid file or by
+// process name.
+type processSignalAction struct {
+	signal      syscall.Signal
+	pidFile     string
+	processName string
+}
+
+// name implements postWriteAction
+func (ps *processSignalAction) name() string {
+	if ps.pidFile != "" {
+		return fmt.Sprintf("signal %s (pid file %s)", signalName(ps.signal), ps.pidFile)
+	}
+	return fmt.Sprintf("signal %s (process %s)", signalName(ps.signal), ps.processName)
+}
+
+// pidFromFile reads the pid in a pid file
+func pidFromFile(pidFile string) (int, error) {
+	pidBytes, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("pid file %s does not contain a valid pid", pidFile)
+	}
+
+	return pid, nil
+}
+
+// procProcessName returns the name (comm) and executable name (basename of argv[0]) of
+// a process, and its parent pid, using /proc
+func procProcessName(pid int) (comm string, exe string, ppid int, err error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	// stat is: pid (comm) state ppid ... and comm may contain spaces or parens
+	openParen := strings.IndexByte(string(stat), '(')
+	closeParen := strings.LastIndexByte(string(stat), ')')
+	if openParen < 0 || closeParen < openParen {
+		return "", "", 0, fmt.Errorf("failed to parse stat of pid %d", pid)
+	}
+	comm = string(stat[openParen+1 : closeParen])
+	fields := strings.Fields(string(stat[closeParen+1:]))
+	if len(fields) < 2 {
+		return "", "", 0, fmt.Errorf("failed to parse stat of pid %d", pid)
+	}
+	ppid, _ = strconv.Atoi(fields[1])
+
+	// cmdline is empty for kernel threads
+	cmdline, _ := os.ReadFile(filepath.Join(procDir, "cmdline"))
+	argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+	// some daemons rewrite argv[0] (e.g. "nginx: master process ...")
+	argv0, _, _ = strings.Cut(argv0, " ")
+	exe = strings.TrimSuffix(filepath.Base(argv0), ":")
+
+	return comm, exe, ppid, nil
+}
+
+// pidsForProcessName returns the pids of the processes named name. If a matching
+// process's parent also matches (e.g. a worker of a master process), only the parent is
+// returned.
+func pidsForProcessName(name string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	// comm is truncated by the kernel
+	commName := name
+	if len(commName) > 15 {
+		commName = commName[:15]
+	}
+
+	self := os.Getpid()
+	parents := map[int]int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		// process may have exited
+		comm, exe, ppid, err := procProcessName(pid)
+		if err != nil {
+			continue
+		}
+
+		if comm == commName || exe == name {
+			parents[pid] = ppid
+		}
+	}
+
+	pids := []int{}
+	for pid, ppid := range parents {
+		if _, parentMatches := parents[ppid]; !parentMatches {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+// run implements postWriteAction
+func (ps *processSignalAction) run(_ context.Context, _, _ []byte) error {
+	var pids []int
+	if ps.pidFile != "" {
+		pid, err := pidFromFile(ps.pidFile)
+		if err != nil {
+			return fmt.Errorf("failed to read pid file (%s)", err)
+		}
+		pids = []int{pid}
+	} else {
+		var err error
+		pids, err = pidsForProcessName(ps.processName)
+		if err != nil {
+			return fmt.Errorf("failed to list processes (%s)", err)
+		}
+		if len(pids) == 0 {
+			return fmt.Errorf("no process named %s was found", ps.processName)
+		}
+	}
+
+	failures := []string{}
+	for _, pid := range pids {
+		err := signalProcess(pid, ps.signal)
+		if errors.Is(err, syscall.ESRCH) {
+			failures = append(failures, fmt.Sprintf("process %d was not found", pid))
+		} else if err != nil {
+			failures = append(failures, fmt.Sprintf("failed to signal process %d (%s)", pid, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
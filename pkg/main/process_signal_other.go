@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// parseSignal returns the signal for a name (e.g. HUP or SIGHUP) or number
+func parseSignal(_ string) (syscall.Signal, error) {
+	return 0, fmt.Errorf("signals %w", errUnsupportedOS)
+}
+
+// signalName returns the name of the signal (e.g. SIGHUP)
+func signalName(sig syscall.Signal) string {
+	return sig.String()
+}
+
+// signalProcess sends the signal to the process with the pid
+func signalProcess(_ int, _ syscall.Signal) error {
+	return fmt.Errorf("signals %w", errUnsupportedOS)
+}
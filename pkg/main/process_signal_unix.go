@@ -0,0 +1,44 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// parseSignal returns the signal for a name (e.g. HUP or SIGHUP) or number
+func parseSignal(signalStr string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(signalStr); err == nil {
+		if unix.SignalName(syscall.Signal(num)) == "" {
+			return 0, fmt.Errorf("unknown signal %d", num)
+		}
+		return syscall.Signal(num), nil
+	}
+
+	name := strings.ToUpper(signalStr)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal %s", signalStr)
+	}
+
+	return sig, nil
+}
+
+// signalName returns the name of the signal (e.g. SIGHUP)
+func signalName(sig syscall.Signal) string {
+	return unix.SignalName(sig)
+}
+
+// signalProcess sends the signal to the process with the pid
+func signalProcess(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
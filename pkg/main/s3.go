@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3MetaSourceHeader is the object metadata header that holds the hash of the key/cert
+// an object was made from (used for change detection since some outputs, such as pfx,
+// differ every time they're made)
+const s3MetaSourceHeader = "X-Amz-Meta-Certwarden-Source"
+
+// s3Client is a minimal client for an s3-compatible api (path-style requests signed
+// with aws signature v4)
+type s3Client struct {
+	endpoint        *url.URL
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	httpClient      *http.Client
+}
+
+// s3URIEncode encodes s per the aws signature v4 rules (everything except unreserved
+// characters, and optionally '/')
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hmacSHA256 returns the hmac-sha256 of data using key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds aws signature v4 authorization to the request. payloadHash is the hex
+// sha256 of the body.
+func (sc *s3Client) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	dateStamp := t.UTC().Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// canonical headers (host and all x-amz-*)
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") {
+			headers[lowerName] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, false),
+		"", // no query
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	// string to sign
+	scope := dateStamp + "/" + sc.region + "/s3/aws4_request"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	// signature
+	signingKey := hmacSHA256([]byte("AWS4"+sc.secretAccessKey), dateStamp)
+	signingKey = hmacSHA256(signingKey, sc.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sc.accessKeyID, scope, signedHeaders, signature))
+}
+
+// do sends a signed request for the object key in bucket
+func (sc *s3Client) do(ctx context.Context, method, bucket, key string, headers map[string]string, body []byte) (*http.Response, error) {
+	objectURL := *sc.endpoint
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + bucket + "/" + key
+	// make sure the path sent is encoded the same way as the path that is signed
+	objectURL.RawPath = s3URIEncode(objectURL.Path, false)
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	sc.sign(req, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	resp, err := sc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// s3Output is an output backend that uploads the configured outputs to an s3-compatible
+// bucket
+type s3Output struct {
+	client *s3Client
+	bucket string
+	prefix string
+	// filenames of the outputs to upload (see app.makeOutputContent)
+	filenames   []string
+	makeContent func(filename string, keyPem, certPem []byte) ([]byte, error)
+	// outputConfig is the config of the outputs (see app.outputConfig)
+	outputConfig string
+	// server side encryption (blank for none)
+	sse         string
+	sseKMSKeyID string
+}
+
+// name implements outputBackend
+func (so *s3Output) name() string {
+	return fmt.Sprintf("s3 objects s3://%s/%s", so.bucket, so.prefix)
+}
+
+// status implements outputBackend
+func (so *s3Output) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	sourceHash := outputSourceHash(keyPem, certPem, so.outputConfig)
+
+	exists = true
+	current = true
+	for _, filename := range so.filenames {
+		resp, err := so.client.do(ctx, http.MethodHead, so.bucket, so.prefix+filename, nil, nil)
+		if err != nil {
+			return false, false, err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			if resp.Header.Get(s3MetaSourceHeader) != sourceHash {
+				current = false
+			}
+
+		case http.StatusNotFound:
+			exists = false
+
+		default:
+			return false, false, fmt.Errorf("error checking object %s (status: %d)", so.prefix+filename, resp.StatusCode)
+		}
+	}
+
+	return exists, exists && current, nil
+}
+
+// write implements outputBackend
+func (so *s3Output) write(ctx context.Context, keyPem, certPem []byte) error {
+	sourceHash := outputSourceHash(keyPem, certPem, so.outputConfig)
+
+	// make all content before uploading anything
+	contents := make([][]byte, len(so.filenames))
+	for i, filename := range so.filenames {
+		var err error
+		contents[i], err = so.makeContent(filename, keyPem, certPem)
+		if err != nil {
+			return fmt.Errorf("failed to make %s (%s)", filename, err)
+		}
+	}
+
+	for i, filename := range so.filenames {
+		headers := map[string]string{
+			"Content-Type":     "application/octet-stream",
+			s3MetaSourceHeader: sourceHash,
+		}
+		if so.sse != "" {
+			headers["X-Amz-Server-Side-Encryption"] = so.sse
+			if so.sseKMSKeyID != "" {
+				headers["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"] = so.sseKMSKeyID
+			}
+		}
+
+		resp, err := so.client.do(ctx, http.MethodPut, so.bucket, so.prefix+filename, headers, contents[i])
+		if err != nil {
+			return fmt.Errorf("failed to upload %s (%s)", so.prefix+filename, err)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error uploading %s (status: %d, %s)", so.prefix+filename, resp.StatusCode, strings.TrimSpace(string(respBody)))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in memory s3-compatible server that stores objects and their source
+// metadata
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	sources map[string]string
+}
+
+func (fs *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		fs.objects[r.URL.Path] = body
+		fs.sources[r.URL.Path] = r.Header.Get(s3MetaSourceHeader)
+
+	case http.MethodHead:
+		if _, ok := fs.objects[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(s3MetaSourceHeader, fs.sources[r.URL.Path])
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// testS3Output returns an s3 output of key.pem and certchain.pem using the client
+func testS3Output(client *s3Client, bucket string) *s3Output {
+	return &s3Output{
+		client:    client,
+		bucket:    bucket,
+		prefix:    "certs/",
+		filenames: []string{"key.pem", "certchain.pem"},
+		makeContent: func(filename string, keyPem, certPem []byte) ([]byte, error) {
+			if filename == "key.pem" {
+				return keyPem, nil
+			}
+			return certPem, nil
+		},
+		outputConfig: "config",
+	}
+}
+
+// testS3OutputRoundTrip checks status before and after a write, and after the key/cert
+// or output config changes
+func testS3OutputRoundTrip(t *testing.T, so *s3Output) {
+	ctx := context.Background()
+	keyPem, certPem := testKeyCert(t, 1)
+
+	exists, current, err := so.status(ctx, keyPem, certPem)
+	if err != nil {
+		t.Fatalf("status failed: %s", err)
+	}
+	if exists || current {
+		t.Fatalf("status before write = (%t, %t), want (false, false)", exists, current)
+	}
+
+	err = so.write(ctx, keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	exists, current, err = so.status(ctx, keyPem, certPem)
+	if err != nil {
+		t.Fatalf("status failed: %s", err)
+	}
+	if !exists || !current {
+		t.Fatalf("status after write = (%t, %t), want (true, true)", exists, current)
+	}
+
+	// new cert
+	newKeyPem, newCertPem := testKeyCert(t, 2)
+	exists, current, err = so.status(ctx, newKeyPem, newCertPem)
+	if err != nil {
+		t.Fatalf("status failed: %s", err)
+	}
+	if !exists || current {
+		t.Fatalf("status for new cert = (%t, %t), want (true, false)", exists, current)
+	}
+
+	// config change
+	so.outputConfig = "changed config"
+	exists, current, err = so.status(ctx, keyPem, certPem)
+	if err != nil {
+		t.Fatalf("status failed: %s", err)
+	}
+	if !exists || current {
+		t.Fatalf("status after config change = (%t, %t), want (true, false)", exists, current)
+	}
+}
+
+func TestS3Output(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, sources: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	endpoint, _ := url.Parse(srv.URL)
+	client := &s3Client{
+		endpoint:        endpoint,
+		region:          "us-east-1",
+		accessKeyID:     "test-key",
+		secretAccessKey: "test-secret",
+		httpClient:      srv.Client(),
+	}
+
+	so := testS3Output(client, "bucket")
+	testS3OutputRoundTrip(t, so)
+
+	// uploaded content
+	keyPem, certPem := testKeyCert(t, 3)
+	err := so.write(context.Background(), keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	if !bytes.Equal(fake.objects["/bucket/certs/certchain.pem"], certPem) {
+		t.Error("uploaded certchain.pem does not match the cert")
+	}
+	if fake.sources["/bucket/certs/key.pem"] != outputSourceHash(keyPem, certPem, so.outputConfig) {
+		t.Error("uploaded key.pem source metadata does not match")
+	}
+}
+
+// TestS3OutputMinIO runs against a real s3-compatible server (e.g. a local MinIO) if
+// CW_CLIENT_TEST_S3_ENDPOINT, CW_CLIENT_TEST_S3_ACCESS_KEY_ID,
+// CW_CLIENT_TEST_S3_SECRET_ACCESS_KEY, and CW_CLIENT_TEST_S3_BUCKET (an existing
+// bucket) are set
+func TestS3OutputMinIO(t *testing.T) {
+	endpointStr := os.Getenv("CW_CLIENT_TEST_S3_ENDPOINT")
+	if endpointStr == "" {
+		t.Skip("CW_CLIENT_TEST_S3_ENDPOINT not set")
+	}
+
+	endpoint, err := url.Parse(endpointStr)
+	if err != nil {
+		t.Fatalf("invalid CW_CLIENT_TEST_S3_ENDPOINT (%s)", err)
+	}
+
+	client := &s3Client{
+		endpoint:        endpoint,
+		region:          "us-east-1",
+		accessKeyID:     os.Getenv("CW_CLIENT_TEST_S3_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("CW_CLIENT_TEST_S3_SECRET_ACCESS_KEY"),
+		httpClient:      http.DefaultClient,
+	}
+
+	so := testS3Output(client, os.Getenv("CW_CLIENT_TEST_S3_BUCKET"))
+	// unique prefix so the objects don't exist yet
+	so.prefix = "certwarden-client-test/" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+	testS3OutputRoundTrip(t, so)
+}
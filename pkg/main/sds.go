@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	envoyCore "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoyTLS "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoyDiscovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	envoySecret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	envoyTypes "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoyServer "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+)
+
+// parseSDSListen parses the sds server listen address in the form unix:/path or
+// [host]:port
+func parseSDSListen(listen string) (network string, address string, err error) {
+	if socketPath, ok := strings.CutPrefix(listen, "unix:"); ok {
+		if socketPath == "" {
+			return "", "", errors.New("socket path is blank")
+		}
+		return "unix", socketPath, nil
+	}
+
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return "", "", fmt.Errorf("invalid tcp address %s (%s)", listen, err)
+	}
+
+	return "tcp", listen, nil
+}
+
+// sdsDataSource returns an envoy inline data source
+func sdsDataSource(data []byte) *envoyCore.DataSource {
+	return &envoyCore.DataSource{
+		Specifier: &envoyCore.DataSource_InlineBytes{InlineBytes: data},
+	}
+}
+
+// updateSDSSecrets sets the sds secrets to the client's current key/cert; connected
+// envoys are pushed the change
+func (app *app) updateSDSSecrets() {
+	if app.sdsCache == nil {
+		return
+	}
+
+	keyPem, certPem := app.tlsCert.Read()
+	if keyPem == nil || certPem == nil {
+		app.logger.Debug("sds: no key/cert to serve yet")
+		return
+	}
+
+	secrets := map[string]envoyTypes.Resource{
+		app.cfg.SDSCertSecretName: &envoyTLS.Secret{
+			Name: app.cfg.SDSCertSecretName,
+			Type: &envoyTLS.Secret_TlsCertificate{
+				TlsCertificate: &envoyTLS.TlsCertificate{
+					CertificateChain: sdsDataSource(certPem),
+					PrivateKey:       sdsDataSource(keyPem),
+				},
+			},
+		},
+	}
+
+	// chain (without the leaf) as the trusted ca
+	caPem, err := makeCABundle(certPem, nil, false)
+	if err != nil {
+		app.logger.Errorf("sds: not serving %s, failed to make ca chain (%s)", app.cfg.SDSValidationSecretName, err)
+	} else {
+		secrets[app.cfg.SDSValidationSecretName] = &envoyTLS.Secret{
+			Name: app.cfg.SDSValidationSecretName,
+			Type: &envoyTLS.Secret_ValidationContext{
+				ValidationContext: &envoyTLS.CertificateValidationContext{
+					TrustedCa: sdsDataSource(caPem),
+				},
+			},
+		}
+	}
+
+	err = app.sdsCache.UpdateResources(secrets, nil)
+	if err != nil {
+		app.logger.Errorf("sds: failed to update secrets (%s)", err)
+		return
+	}
+
+	app.logger.Info("sds: secrets updated")
+}
+
+// startSDSServer starts a grpc server that serves the client's key/cert to envoy using
+// the secret discovery service
+func (app *app) startSDSServer() error {
+	network, address, err := parseSDSListen(app.cfg.SDSListen)
+	if err != nil {
+		return err
+	}
+
+	app.updateSDSSecrets()
+
+	// log envoy connections and rejections
+	callbacks := envoyServer.CallbackFuncs{
+		StreamOpenFunc: func(_ context.Context, streamID int64, typeURL string) error {
+			app.logger.Debugf("sds: stream %d opened (%s)", streamID, typeURL)
+			return nil
+		},
+		StreamClosedFunc: func(streamID int64, node *envoyCore.Node) {
+			app.logger.Debugf("sds: stream %d closed (node: %s)", streamID, node.GetId())
+		},
+		StreamRequestFunc: func(streamID int64, req *envoyDiscovery.DiscoveryRequest) error {
+			if req.GetErrorDetail() != nil {
+				app.logger.Errorf("sds: envoy (node: %s) rejected secret(s) %s (%s)", req.GetNode().GetId(), req.GetResourceNames(), req.GetErrorDetail().GetMessage())
+			} else if req.GetResponseNonce() != "" {
+				app.logger.Debugf("sds: envoy (node: %s) accepted secret(s) %s version %s", req.GetNode().GetId(), req.GetResourceNames(), req.GetVersionInfo())
+			}
+			return nil
+		},
+	}
+
+	// remove stale socket
+	if network == "unix" {
+		err = os.Remove(address)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("sds server failed to remove existing socket %s (%s)", address, err)
+		}
+	}
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("sds server cannot bind to %s (%s)", app.cfg.SDSListen, err)
+	}
+
+	// streams end when the shutdown context closes
+	xdsServer := envoyServer.NewServer(app.shutdownContext, app.sdsCache, callbacks)
+	grpcServer := grpc.NewServer()
+	envoySecret.RegisterSecretDiscoveryServiceServer(grpcServer, xdsServer)
+
+	app.logger.Infof("starting sds server bound to %s", app.cfg.SDSListen)
+
+	// start server
+	app.shutdownWaitgroup.Add(1)
+	go func() {
+		err := grpcServer.Serve(ln)
+		if err != nil {
+			app.logger.Errorf("sds server returned error (%s)", err)
+		}
+
+		app.logger.Info("sds server shutdown complete")
+		app.shutdownWaitgroup.Done()
+	}()
+
+	// shutdown server when shutdown context closes
+	go func() {
+		<-app.shutdownContext.Done()
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(30 * time.Second):
+			app.logger.Error("sds server graceful shutdown timed out, stopping")
+			grpcServer.Stop()
+		}
+	}()
+
+	return nil
+}
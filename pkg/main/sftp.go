@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpSourceFilename is a file written to the remote dir (after all other files and the
+// reload command succeed) that contains the hash of the key/cert the files were made
+// from; it is used to determine if the remote host is current
+const sftpSourceFilename = ".certwarden-source"
+
+// sftpOutput is an output backend that copies the configured outputs to a remote host
+// over sftp and then optionally runs a reload command on it
+type sftpOutput struct {
+	host          string
+	sshConfig     *ssh.ClientConfig
+	remoteDir     string
+	reloadCommand string
+	// filenames of the outputs to copy (see app.makeOutputContent)
+	filenames   []string
+	makeContent func(filename string, keyPem, certPem []byte) ([]byte, fs.FileMode, error)
+	// outputConfig is the config of the outputs (see app.outputConfig)
+	outputConfig string
+}
+
+// newSftpSSHConfig returns the ssh client config for the user, private key file, and
+// known_hosts file
+func newSftpSSHConfig(user, keyFile, keyPassphrase, knownHostsFile string) (*ssh.ClientConfig, error) {
+	keyPem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key (%s)", err)
+	}
+
+	var signer ssh.Signer
+	if keyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyPem, []byte(keyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyPem)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key (%s)", err)
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts (%s)", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// name implements outputBackend
+func (so *sftpOutput) name() string {
+	return fmt.Sprintf("sftp host %s:%s", so.host, so.remoteDir)
+}
+
+// connect opens an ssh connection to the host. The connection is closed if ctx is done.
+func (so *sftpOutput) connect(ctx context.Context) (*ssh.Client, error) {
+	dialer := &net.Dialer{Timeout: so.sshConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", so.host)
+	if err != nil {
+		return nil, err
+	}
+
+	// close conn if ctx is done (e.g. timeout) since ssh doesn't use ctx
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, so.host, so.sshConfig)
+	if err != nil {
+		stop()
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// status implements outputBackend
+func (so *sftpOutput) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	sshClient, err := so.connect(ctx)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to connect (%s)", err)
+	}
+	defer sshClient.Close()
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to start sftp (%s)", err)
+	}
+	defer sftpClient.Close()
+
+	// all files exist?
+	for _, filename := range so.filenames {
+		_, err = sftpClient.Stat(path.Join(so.remoteDir, filename))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, false, nil
+		} else if err != nil {
+			return false, false, fmt.Errorf("failed to stat %s (%s)", filename, err)
+		}
+	}
+
+	// current?
+	sourceFile, err := sftpClient.Open(path.Join(so.remoteDir, sftpSourceFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, false, nil
+	} else if err != nil {
+		return false, false, fmt.Errorf("failed to open %s (%s)", sftpSourceFilename, err)
+	}
+	defer sourceFile.Close()
+
+	source, err := io.ReadAll(io.LimitReader(sourceFile, 1024))
+	if err != nil {
+		return false, false, fmt.Errorf("failed to read %s (%s)", sftpSourceFilename, err)
+	}
+
+	return true, string(bytes.TrimSpace(source)) == outputSourceHash(keyPem, certPem, so.outputConfig), nil
+}
+
+// sftpWriteFileAtomic writes data to a temp file in the remote dir and then renames it
+// over name
+func sftpWriteFileAtomic(sftpClient *sftp.Client, name string, data []byte, perm fs.FileMode) error {
+	tmpName := path.Join(path.Dir(name), "."+path.Base(name)+".tmp")
+
+	f, err := sftpClient.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = sftpClient.Remove(tmpName)
+		return err
+	}
+
+	// posix rename replaces an existing file, fallback to remove and rename if the
+	// server doesn't support the extension
+	err = sftpClient.PosixRename(tmpName, name)
+	if err != nil {
+		_ = sftpClient.Remove(name)
+		err = sftpClient.Rename(tmpName, name)
+		if err != nil {
+			_ = sftpClient.Remove(tmpName)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write implements outputBackend
+func (so *sftpOutput) write(ctx context.Context, keyPem, certPem []byte) error {
+	// make all content before connecting
+	contents := make([][]byte, len(so.filenames))
+	perms := make([]fs.FileMode, len(so.filenames))
+	for i, filename := range so.filenames {
+		var err error
+		contents[i], perms[i], err = so.makeContent(filename, keyPem, certPem)
+		if err != nil {
+			return fmt.Errorf("failed to make %s (%s)", filename, err)
+		}
+	}
+
+	sshClient, err := so.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect (%s)", err)
+	}
+	defer sshClient.Close()
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp (%s)", err)
+	}
+	defer sftpClient.Close()
+
+	err = sftpClient.MkdirAll(so.remoteDir)
+	if err != nil {
+		return fmt.Errorf("failed to make remote dir (%s)", err)
+	}
+
+	// copy files
+	for i, filename := range so.filenames {
+		err = sftpWriteFileAtomic(sftpClient, path.Join(so.remoteDir, filename), contents[i], perms[i])
+		if err != nil {
+			return fmt.Errorf("failed to write %s (%s)", filename, err)
+		}
+	}
+
+	// reload
+	if so.reloadCommand != "" {
+		session, err := sshClient.NewSession()
+		if err != nil {
+			return fmt.Errorf("failed to start ssh session for reload command (%s)", err)
+		}
+		defer session.Close()
+
+		output, err := session.CombinedOutput(so.reloadCommand)
+		if err != nil {
+			return fmt.Errorf("reload command failed (%s): %s", err, bytes.TrimSpace(output))
+		}
+	}
+
+	// record what was written (last, so any failure above results in a retry)
+	err = sftpWriteFileAtomic(sftpClient, path.Join(so.remoteDir, sftpSourceFilename), []byte(outputSourceHash(keyPem, certPem, so.outputConfig)+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s (%s)", sftpSourceFilename, err)
+	}
+
+	return nil
+}
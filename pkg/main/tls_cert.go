@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"golang.org/x/crypto/ocsp"
 )
 
 // SafeCert is a struct to hold and manage a tls certificate
@@ -16,6 +18,12 @@ type SafeCert struct {
 
 	cert *tls.Certificate
 
+	// stapledCert is cert with an ocsp staple attached; it is only used until the
+	// staple's next update
+	stapledCert       *tls.Certificate
+	stapleNextUpdate  time.Time
+	stapleRefreshTime time.Time
+
 	sync.RWMutex
 }
 
@@ -30,6 +38,11 @@ func (sc *SafeCert) TlsCertFunc() func(*tls.ClientHelloInfo) (*tls.Certificate,
 		sc.RLock()
 		defer sc.RUnlock()
 
+		// use stapled cert unless staple has gone stale
+		if sc.stapledCert != nil && (sc.stapleNextUpdate.IsZero() || time.Now().Before(sc.stapleNextUpdate)) {
+			return sc.stapledCert, nil
+		}
+
 		return sc.cert, nil
 	}
 }
@@ -94,8 +107,42 @@ func (sc *SafeCert) Update(keyPem, certPem []byte) (updated bool, err error) {
 		return false, fmt.Errorf("failed to make x509 key pair for tls cert update (%s)", err)
 	}
 
-	// update certificate
+	// update certificate (old staple isn't valid for the new cert)
 	sc.cert = &tlsCert
+	sc.stapledCert = nil
+	sc.stapleNextUpdate = time.Time{}
+	sc.stapleRefreshTime = time.Time{}
 
 	return true, nil
 }
+
+// SetOCSPStaple attaches the ocsp response to the certificate, if certPem (the cert the
+// response was fetched for) is still the current cert. The response must already be
+// validated. It returns true if the staple was set.
+func (sc *SafeCert) SetOCSPStaple(certPem []byte, raw []byte, resp *ocsp.Response, refreshTime time.Time) bool {
+	sc.Lock()
+	defer sc.Unlock()
+
+	// cert changed while the response was being fetched
+	if sc.cert == nil || !bytes.Equal(sc.certPem, certPem) {
+		return false
+	}
+
+	stapledCert := *sc.cert
+	stapledCert.OCSPStaple = raw
+
+	sc.stapledCert = &stapledCert
+	sc.stapleNextUpdate = resp.NextUpdate
+	sc.stapleRefreshTime = refreshTime
+
+	return true
+}
+
+// OCSPStapleRefreshTime returns when the current ocsp staple should be refreshed (zero
+// if there is no staple)
+func (sc *SafeCert) OCSPStapleRefreshTime() time.Time {
+	sc.RLock()
+	defer sc.RUnlock()
+
+	return sc.stapleRefreshTime
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// traefik dynamic config formats
+const (
+	traefikFormatYAML = "yaml"
+	traefikFormatTOML = "toml"
+)
+
+// traefikConfigOptions are the options for the traefik dynamic config output
+type traefikConfigOptions struct {
+	Format string
+	// CertPath is the path of the cert storage dir as seen by traefik (e.g. inside the
+	// traefik container)
+	CertPath string
+	// DefaultCert also makes the cert the default cert of traefik's default store
+	DefaultCert bool
+}
+
+// traefikQuote returns s as a double quoted string that is valid in both yaml and toml
+func traefikQuote(s string) string {
+	// json string escaping is a subset of both
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// makeTraefikConfig returns a traefik dynamic config that loads key.pem and
+// certchain.pem. Traefik skips reloading a dynamic config that hasn't changed (even if
+// the files it references did), so the config also defines an (unused) tls option named
+// after the cert's fingerprint; this makes the config change with each new cert.
+func makeTraefikConfig(certPem []byte, opts traefikConfigOptions) ([]byte, error) {
+	fingerprint, err := certFingerprint(certPem)
+	if err != nil {
+		return nil, err
+	}
+
+	certFile := traefikQuote(path.Join(opts.CertPath, "certchain.pem"))
+	keyFile := traefikQuote(path.Join(opts.CertPath, "key.pem"))
+	optionName := traefikQuote("certwarden-" + fingerprint[:16])
+
+	var b strings.Builder
+	b.WriteString("# generated by cert warden client, do not edit\n")
+	b.WriteString("# cert sha256 fingerprint: " + fingerprint + "\n")
+
+	switch opts.Format {
+	case traefikFormatYAML:
+		b.WriteString("tls:\n")
+		b.WriteString("  certificates:\n")
+		b.WriteString("    - certFile: " + certFile + "\n")
+		b.WriteString("      keyFile: " + keyFile + "\n")
+		if opts.DefaultCert {
+			b.WriteString("  stores:\n")
+			b.WriteString("    default:\n")
+			b.WriteString("      defaultCertificate:\n")
+			b.WriteString("        certFile: " + certFile + "\n")
+			b.WriteString("        keyFile: " + keyFile + "\n")
+		}
+		b.WriteString("  options:\n")
+		b.WriteString("    " + optionName + ": {}\n")
+
+	case traefikFormatTOML:
+		b.WriteString("[[tls.certificates]]\n")
+		b.WriteString("  certFile = " + certFile + "\n")
+		b.WriteString("  keyFile = " + keyFile + "\n")
+		if opts.DefaultCert {
+			b.WriteString("\n[tls.stores.default.defaultCertificate]\n")
+			b.WriteString("  certFile = " + certFile + "\n")
+			b.WriteString("  keyFile = " + keyFile + "\n")
+		}
+		b.WriteString("\n[tls.options." + optionName + "]\n")
+
+	default:
+		return nil, fmt.Errorf("invalid traefik config format \"%s\"", opts.Format)
+	}
+
+	return []byte(b.String()), nil
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,28 @@ func (app *app) updateCertFilesAndRestartContainers(onlyIfMissing bool) (diskNee
 	// get current pem data from client
 	keyPemApp, certPemApp := app.tlsCert.Read()
 
+	// don't write a cert that was rolled back
+	if app.certIsHeld(certPemApp) {
+		app.logger.Warn("key/cert file(s) write: not performed, current cert was rolled back and won't be written until a newer cert is received")
+		return false
+	}
+
+	// a key format that can't be used with the key type can't be fixed by retrying, the
+	// key type or the format config must be changed
+	err := app.checkKeyFormats(keyPemApp)
+	if err != nil {
+		app.logger.Errorf("key/cert file(s) write: not performed and won't be retried until a new key/cert is received, a configured key format can't be used with this key (%s)", err)
+		return false
+	}
+
+	// key.pem content (in the configured format)
+	keyPemFile, err := convertKeyPem(keyPemApp, app.cfg.KeyFormat)
+	if err != nil {
+		// key and cert must be written together, so don't write anything
+		app.logger.Errorf("key/cert file(s) write: not performed, failed to convert key.pem to configured format (%s)", err)
+		return true
+	}
+
 	// read key.pem
 	keyFileExists := true
 	keyFileUpdated := false
@@ -31,7 +55,7 @@ func (app *app) updateCertFilesAndRestartContainers(onlyIfMissing bool) (diskNee
 			// if cant read file, treat as if doesn't exist
 			keyFileExists = false
 			app.logger.Errorf("could not read key.pem from disk (%s), will treat as non-existing", err)
-		} else if !bytes.Equal(pemFile, keyPemApp) {
+		} else if !bytes.Equal(pemFile, keyPemFile) {
 			// if file and app pem are different, its an update
 			keyFileUpdated = true
 		}
@@ -72,101 +96,257 @@ func (app *app) updateCertFilesAndRestartContainers(onlyIfMissing bool) (diskNee
 		}
 	}
 
-	// check for modern pfx
-	modernPfxFileExists := true
-	if _, err := os.Stat(app.cfg.CertStoragePath + "/" + app.cfg.PfxFilename); errors.Is(err, os.ErrNotExist) {
-		modernPfxFileExists = false
+	// check for additional output files
+	additionalFiles := app.additionalOutputFiles()
+	additionalFilesExist := make([]bool, len(additionalFiles))
+	additionalFilesUpdated := make([]bool, len(additionalFiles))
+	additionalFilesContent := make([][]byte, len(additionalFiles))
+	anyAdditionalFileMissing := false
+	anyAdditionalFileUpdated := false
+	for i := range additionalFiles {
+		additionalFilesExist[i] = true
+		if _, err := os.Stat(app.cfg.CertStoragePath + "/" + additionalFiles[i].filename); errors.Is(err, os.ErrNotExist) {
+			additionalFilesExist[i] = false
+		}
+
+		// if content is comparable, make it now and compare to disk
+		if additionalFiles[i].compareContent {
+			additionalFilesContent[i], err = additionalFiles[i].make(keyPemApp, certPemApp)
+			if err != nil {
+				app.logger.Errorf("failed to make %s %s (%s)", additionalFiles[i].description, additionalFiles[i].filename, err)
+				// can't compare or write
+				additionalFilesContent[i] = nil
+			}
+
+			if additionalFilesExist[i] {
+				fileContent, err := os.ReadFile(app.cfg.CertStoragePath + "/" + additionalFiles[i].filename)
+				if err != nil {
+					// if cant read file, treat as if doesn't exist
+					additionalFilesExist[i] = false
+					app.logger.Errorf("could not read %s from disk (%s), will treat as non-existing", additionalFiles[i].filename, err)
+				} else if additionalFilesContent[i] != nil && !bytes.Equal(fileContent, additionalFilesContent[i]) {
+					additionalFilesUpdated[i] = true
+					anyAdditionalFileUpdated = true
+				}
+			}
+		}
+
+		if !additionalFilesExist[i] {
+			anyAdditionalFileMissing = true
+		}
 	}
 
-	// check for legacy pfx
-	legacyPfxFileExists := true
-	if _, err := os.Stat(app.cfg.CertStoragePath + "/" + app.cfg.PfxLegacyFilename); errors.Is(err, os.ErrNotExist) {
-		legacyPfxFileExists = false
+	// check output backends (concurrently, so one that is unreachable doesn't delay the rest)
+	backendsExist := make([]bool, len(app.outputBackends))
+	backendsCurrent := make([]bool, len(app.outputBackends))
+	backendsStatusFailed := make([]bool, len(app.outputBackends))
+	wg := new(sync.WaitGroup)
+	for i, backend := range app.outputBackends {
+		wg.Add(1)
+		go func(i int, backend outputBackend) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(app.shutdownContext, outputBackendTimeout)
+			defer cancel()
+
+			var err error
+			backendsExist[i], backendsCurrent[i], err = backend.status(ctx, keyPemApp, certPemApp)
+			if err != nil {
+				app.logger.Errorf("could not check %s (%s)", backend.name(), err)
+				backendsStatusFailed[i] = true
+			}
+		}(i, backend)
 	}
+	wg.Wait()
 
-	// calculate if any desired files are missing
-	anyFileMissing := !keyFileExists || !certFileExists || (app.cfg.PfxCreate && !modernPfxFileExists) || (app.cfg.PfxLegacyCreate && !legacyPfxFileExists)
+	anyBackendMissing := false
+	anyBackendUpdated := false
+	for i := range app.outputBackends {
+		if backendsStatusFailed[i] {
+			continue
+		}
+
+		if !backendsExist[i] {
+			anyBackendMissing = true
+		} else if !backendsCurrent[i] {
+			anyBackendUpdated = true
+		}
+	}
+
+	// calculate if any desired files (or backends) are missing
+	anyFileMissing := !keyFileExists || !certFileExists || anyAdditionalFileMissing || anyBackendMissing
 	// track if any new files are written; at end, if yes, restart containers
 	wroteAnyFiles := false
 	failedAnyWrite := false
 
+	// files that need to be written
+	pendingWrites := []fileWrite{}
+
 	// write key pem (always if not exist, if exists but updated: only write if NOT only missing files OR any file is missing)
 	// AKA write file anyway even if !onlyIfMissing if something else is missing, because something will be written and trigger restart anyway
 	if !keyFileExists || (keyFileUpdated && (!onlyIfMissing || anyFileMissing)) {
-		err := os.WriteFile(app.cfg.CertStoragePath+"/key.pem", keyPemApp, app.cfg.KeyPermissions)
-		if err != nil {
-			app.logger.Errorf("failed to write key.pem (%s)", err)
-			failedAnyWrite = true
-			// failed, but keep trying
-		} else {
-			wroteAnyFiles = true
-			app.logger.Info("wrote new key.pem file")
-		}
+		pendingWrites = append(pendingWrites, fileWrite{filename: "key.pem", data: keyPemFile, perm: app.cfg.KeyPermissions})
 	}
 
 	// write cert pem
 	if !certFileExists || (certFileUpdated && (!onlyIfMissing || anyFileMissing)) {
-		err := os.WriteFile(app.cfg.CertStoragePath+"/certchain.pem", certPemApp, app.cfg.CertPermissions)
-		if err != nil {
-			app.logger.Errorf("failed to write certchain.pem (%s)", err)
-			failedAnyWrite = true
-			// failed, but keep trying
-		} else {
-			wroteAnyFiles = true
-			app.logger.Info("wrote new certchain.pem file")
-		}
+		pendingWrites = append(pendingWrites, fileWrite{filename: "certchain.pem", data: certPemApp, perm: app.cfg.CertPermissions})
 	}
 
 	// use key/cert updated as proxy for other files updated check
 	keyOrCertFileUpdated := keyFileUpdated || certFileUpdated
 
-	// write modern pfx (if enabled)
-	if app.cfg.PfxCreate && (!modernPfxFileExists || (keyOrCertFileUpdated && (!onlyIfMissing || anyFileMissing))) {
-		pfx, err := makeModernPfx(keyPemApp, certPemApp, app.cfg.PfxPassword)
-		if err != nil {
-			app.logger.Errorf("failed to make modern pfx (%s)", err)
-			// failed, but keep trying
+	// write additional files (if enabled)
+	for i, output := range additionalFiles {
+		// comparable file that already failed to make (and was logged)
+		if output.compareContent && additionalFilesContent[i] == nil {
 			failedAnyWrite = true
-		} else {
-			err = os.WriteFile(app.cfg.CertStoragePath+"/"+app.cfg.PfxFilename, pfx, app.cfg.KeyPermissions)
-			if err != nil {
-				app.logger.Errorf("failed to write %s (%s)", app.cfg.PfxFilename, err)
-				// failed, but keep trying
-				failedAnyWrite = true
-			} else {
-				app.logger.Infof("wrote new modern pfx %s file", app.cfg.PfxFilename)
-				wroteAnyFiles = true
+			continue
+		}
+
+		// comparable files use their actual comparison instead of the proxy
+		updated := keyOrCertFileUpdated
+		if output.compareContent {
+			updated = additionalFilesUpdated[i]
+		}
+
+		if !additionalFilesExist[i] || (updated && (!onlyIfMissing || anyFileMissing)) {
+			data := additionalFilesContent[i]
+			if data == nil {
+				data, err = output.make(keyPemApp, certPemApp)
+				if err != nil {
+					app.logger.Errorf("failed to make %s %s (%s)", output.description, output.filename, err)
+					// failed, but keep trying
+					failedAnyWrite = true
+					continue
+				}
 			}
+
+			pendingWrites = append(pendingWrites, fileWrite{filename: output.filename, data: data, perm: output.perm})
 		}
 	}
 
-	// write legacy pfx (if enabled)
-	if app.cfg.PfxLegacyCreate && (!legacyPfxFileExists || (keyOrCertFileUpdated && (!onlyIfMissing || anyFileMissing))) {
-		pfx, err := makeLegacyPfx(keyPemApp, certPemApp, app.cfg.PfxLegacyPassword)
+	// a versioned set is all or nothing, so don't write it if any file failed to generate
+	if app.cfg.FileVersionedSet && failedAnyWrite {
+		pendingWrites = nil
+	}
+
+	// output backends that need writing
+	backendsNeedWrite := make([]bool, len(app.outputBackends))
+	anyBackendNeedsWrite := false
+	for i := range app.outputBackends {
+		if !backendsStatusFailed[i] && (!backendsExist[i] || (!backendsCurrent[i] && (!onlyIfMissing || anyFileMissing))) {
+			backendsNeedWrite[i] = true
+			anyBackendNeedsWrite = true
+		}
+	}
+
+	// run pre-write hooks (if anything will be written), a failure aborts the write
+	if len(app.preWriteHooks) > 0 && (len(pendingWrites) > 0 || anyBackendNeedsWrite) {
+		pendingFilenames := []string{}
+		for _, pendingWrite := range pendingWrites {
+			pendingFilenames = append(pendingFilenames, pendingWrite.filename)
+		}
+
+		env := app.execHookEnv(execHookStagePreWrite, certPemApp, pendingFilenames)
+		if app.runExecHooks(execHookStagePreWrite, app.preWriteHooks, env, true) {
+			app.logger.Error("key/cert file(s) write: not performed, a pre-write hook failed")
+			return true
+		}
+	}
+
+	// archive the files being replaced (so they can be rolled back to)
+	if app.cfg.ArchiveCount > 0 && len(pendingWrites) > 0 && keyFileExists && certFileExists {
+		err := app.archiveCurrentFiles()
 		if err != nil {
-			app.logger.Errorf("failed to make legacy pfx (%s)", err)
-			// failed, but keep trying
+			app.logger.Errorf("failed to archive current files before replacing them (%s)", err)
+		}
+	}
+
+	// write all of the files that need writing
+	failedAnyFileWrite := false
+	if len(pendingWrites) > 0 {
+		wroteAnyFiles, failedAnyFileWrite = app.writeFiles(pendingWrites)
+		failedAnyWrite = failedAnyWrite || failedAnyFileWrite
+	}
+
+	// write output backends
+	wroteAnyBackends := false
+	copiedToContainers := false
+	for i, backend := range app.outputBackends {
+		// status check failed (and was logged)
+		if backendsStatusFailed[i] {
 			failedAnyWrite = true
-		} else {
-			err = os.WriteFile(app.cfg.CertStoragePath+"/"+app.cfg.PfxLegacyFilename, pfx, app.cfg.KeyPermissions)
+			continue
+		}
+
+		if backendsNeedWrite[i] {
+			ctx, cancel := context.WithTimeout(app.shutdownContext, outputBackendTimeout)
+			err = backend.write(ctx, keyPemApp, certPemApp)
+			cancel()
 			if err != nil {
-				app.logger.Errorf("failed to write legacy pfx %s (%s)", app.cfg.PfxLegacyFilename, err)
+				app.logger.Errorf("failed to write %s (%s)", backend.name(), err)
 				// failed, but keep trying
 				failedAnyWrite = true
 			} else {
-				app.logger.Infof("wrote new legacy pfx %s file", app.cfg.PfxLegacyFilename)
-				wroteAnyFiles = true
+				app.logger.Infof("wrote new %s", backend.name())
+				wroteAnyBackends = true
+				if _, isDockerCopy := backend.(*dockerCopyOutput); isDockerCopy {
+					copiedToContainers = true
+				}
 			}
 		}
 	}
 
-	// done updating files, restart docker containers (if any files written)
-	if len(app.cfg.DockerContainersToRestart) > 0 {
-		if wroteAnyFiles {
-			app.logger.Info("at least one file changed, updating docker containers")
-			app.restartOrStopDockerContainers()
-		} else {
+	// archive the new complete set of files (output backends don't affect the files on disk)
+	if app.cfg.ArchiveCount > 0 && wroteAnyFiles && !failedAnyFileWrite {
+		err := app.archiveCurrentFiles()
+		if err != nil {
+			app.logger.Errorf("failed to archive new files (%s)", err)
+		}
+	}
+
+	// new cert on disk needs a new ocsp staple
+	if wroteAnyFiles {
+		requestOCSPRefresh(app.ocspStapleFileRefresh)
+	}
+
+	// tell services about the new files (if any files written)
+	if wroteAnyFiles && len(app.postWriteActions) > 0 {
+		app.runPostWriteActions(keyPemApp, certPemApp)
+	}
+
+	// run post-write hooks (if any files written)
+	if wroteAnyFiles && len(app.postWriteHooks) > 0 {
+		writtenFilenames := []string{}
+		for _, pendingWrite := range pendingWrites {
+			writtenFilenames = append(writtenFilenames, pendingWrite.filename)
+		}
+
+		env := app.execHookEnv(execHookStagePostWrite, certPemApp, writtenFilenames)
+		app.runExecHooks(execHookStagePostWrite, app.postWriteHooks, env, false)
+	}
+
+	// done updating files, restart docker containers (if any files written or copied into them)
+	if app.dockerUpdatesConfigured() {
+		if !wroteAnyFiles && !copiedToContainers {
 			app.logger.Debug("not updating docker containers, no changes were written to disk")
+		} else {
+			app.logger.Info("at least one file changed, updating docker containers")
+			err = app.restartOrStopDockerContainers()
+			if err != nil && app.cfg.DockerAutoRollback {
+				app.logger.Errorf("key/cert file(s) write: docker containers failed with the new files (%s), rolling back to the previous files", err)
+				err = app.rollbackToPreviousVersion(certPemApp)
+				if err != nil {
+					// nothing was held, so keep trying
+					app.logger.Errorf("key/cert file(s) write: automatic rollback failed (%s), the new files are still in place", err)
+					return true
+				}
+				app.logger.Error("key/cert file(s) write: rolled back to the previous files, the new cert won't be written again until a newer cert is received")
+
+				// the new cert is held, so only failed writes (e.g. output backends) are retried
+				return failedAnyWrite
+			}
 		}
 	}
 
@@ -176,11 +356,11 @@ func (app *app) updateCertFilesAndRestartContainers(onlyIfMissing bool) (diskNee
 		// any write failure
 		app.logger.Error("key/cert file(s) write: at least one write failed")
 		diskNeedsUpdate = true
-	} else if wroteAnyFiles {
+	} else if wroteAnyFiles || wroteAnyBackends {
 		// no write failure, and wrote file(s)
 		app.logger.Info("key/cert file(s) write: successfully wrote complete disk update")
 		diskNeedsUpdate = false
-	} else if keyOrCertFileUpdated && !wroteAnyFiles /* && not needed but just in case above code changes */ {
+	} else if (keyOrCertFileUpdated || anyAdditionalFileUpdated || anyBackendUpdated) && !wroteAnyFiles && !wroteAnyBackends /* && not needed but just in case above code changes */ {
 		// didn't write any files but update needed
 		app.logger.Info("key/cert file(s) write: not performed, but a write is needed")
 		diskNeedsUpdate = true
@@ -206,6 +386,10 @@ func (app *app) updateClientCert(keyPem, certPem []byte) error {
 	// log
 	if updated {
 		app.logger.Infof("new tls key/cert installed in https server")
+		// new cert needs a new ocsp staple
+		requestOCSPRefresh(app.httpsOCSPStapleRefresh)
+		// push to envoy
+		app.updateSDSSecrets()
 	} else {
 		app.logger.Infof("new tls key/cert same as current, no update performed")
 	}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testAutoRollbackApp returns an app that updates the nginx container of a fake docker
+// api, waiting for it to be healthy and rolling back if it isn't
+func testAutoRollbackApp(t *testing.T) (*app, *fakeDockerAPI) {
+	t.Helper()
+
+	app := testApp(t)
+	app.cfg.ArchiveCount = 2
+	app.cfg.DockerContainersToRestart = []dockerContainer{{Name: "nginx"}}
+	app.cfg.DockerWaitHealthy = true
+	app.cfg.DockerHealthTimeoutSeconds = 5
+	app.cfg.DockerAutoRollback = true
+
+	return app, testFakeDocker(t, app, "nginx")
+}
+
+// testSetAppCert sets the app's key/cert
+func testSetAppCert(t *testing.T, app *app, keyPem, certPem []byte) {
+	t.Helper()
+
+	_, err := app.tlsCert.Update(keyPem, certPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateCertFilesAutoRollback(t *testing.T) {
+	app, fakeDocker := testAutoRollbackApp(t)
+	fakeDocker.containers["nginx"].healthAfterUpdates = []string{"healthy", "unhealthy", "healthy"}
+
+	// first cert
+	oldKeyPem, oldCertPem := testKeyCert(t, 1)
+	testSetAppCert(t, app, oldKeyPem, oldCertPem)
+	if app.updateCertFilesAndRestartContainers(false) {
+		t.Fatal("first write needs a retry")
+	}
+
+	// new cert makes the container unhealthy, so the previous files are reinstated
+	newKeyPem, newCertPem := testKeyCert(t, 2)
+	testSetAppCert(t, app, newKeyPem, newCertPem)
+	if app.updateCertFilesAndRestartContainers(false) {
+		t.Error("successful rollback needs a retry")
+	}
+
+	certPem, _ := os.ReadFile(filepath.Join(app.cfg.CertStoragePath, "certchain.pem"))
+	if !bytes.Equal(certPem, oldCertPem) {
+		t.Error("previous cert was not reinstated")
+	}
+	if !app.certIsHeld(newCertPem) {
+		t.Error("rolled back cert is not held")
+	}
+	if actions := fakeDocker.actionsString(); actions != "restart nginx, restart nginx, restart nginx" {
+		t.Errorf("container actions = \"%s\", want 3 restarts", actions)
+	}
+
+	// held cert isn't written again
+	if app.updateCertFilesAndRestartContainers(false) {
+		t.Error("held cert needs a retry")
+	}
+	certPem, _ = os.ReadFile(filepath.Join(app.cfg.CertStoragePath, "certchain.pem"))
+	if !bytes.Equal(certPem, oldCertPem) {
+		t.Error("held cert was written")
+	}
+}
+
+func TestUpdateCertFilesAutoRollbackFailed(t *testing.T) {
+	app, fakeDocker := testAutoRollbackApp(t)
+	fakeDocker.containers["nginx"].health = "unhealthy"
+
+	// first cert, so there is nothing to roll back to
+	keyPem, certPem := testKeyCert(t, 1)
+	testSetAppCert(t, app, keyPem, certPem)
+	if !app.updateCertFilesAndRestartContainers(false) {
+		t.Error("failed rollback doesn't need a retry")
+	}
+
+	if app.certIsHeld(certPem) {
+		t.Error("cert is held after a failed rollback")
+	}
+	writtenCertPem, _ := os.ReadFile(filepath.Join(app.cfg.CertStoragePath, "certchain.pem"))
+	if !bytes.Equal(writtenCertPem, certPem) {
+		t.Error("new files were not left in place")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// vaultClient is a minimal client for the vault http api
+type vaultClient struct {
+	address    string
+	namespace  string
+	httpClient *http.Client
+
+	// token auth
+	token string
+
+	// approle auth
+	appRoleMount    string
+	appRoleRoleID   string
+	appRoleSecretID string
+
+	// token from approle login
+	loginToken        string
+	loginTokenExpires time.Time
+	loginMu           sync.Mutex
+}
+
+// vaultAuthResponse is the response to a vault login
+type vaultAuthResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+// authToken returns the token to use for requests, logging in with approle if needed
+func (vc *vaultClient) authToken(ctx context.Context) (string, error) {
+	if vc.token != "" {
+		return vc.token, nil
+	}
+
+	vc.loginMu.Lock()
+	defer vc.loginMu.Unlock()
+
+	// existing login still good
+	if vc.loginToken != "" && time.Now().Before(vc.loginTokenExpires) {
+		return vc.loginToken, nil
+	}
+
+	loginPayload := map[string]string{
+		"role_id":   vc.appRoleRoleID,
+		"secret_id": vc.appRoleSecretID,
+	}
+
+	var authResp vaultAuthResponse
+	statusCode, err := vc.doWithToken(ctx, "", http.MethodPost, "/v1/auth/"+vc.appRoleMount+"/login", loginPayload, &authResp)
+	if err != nil {
+		return "", fmt.Errorf("approle login failed (%s)", err)
+	}
+	if statusCode != http.StatusOK || authResp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("approle login failed (status: %d)", statusCode)
+	}
+
+	// renew login when 80% of the lease has passed (a 0 lease doesn't expire)
+	vc.loginToken = authResp.Auth.ClientToken
+	vc.loginTokenExpires = time.Now().Add(100 * 365 * 24 * time.Hour)
+	if authResp.Auth.LeaseDuration > 0 {
+		vc.loginTokenExpires = time.Now().Add(time.Duration(authResp.Auth.LeaseDuration) * time.Second * 8 / 10)
+	}
+
+	return vc.loginToken, nil
+}
+
+// do sends a json request to the vault api and decodes the json response into
+// respPayload (if not nil). If an approle token was rejected, it logs in again and
+// retries once.
+func (vc *vaultClient) do(ctx context.Context, method, path string, reqPayload any, respPayload any) (statusCode int, err error) {
+	token, err := vc.authToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	statusCode, err = vc.doWithToken(ctx, token, method, path, reqPayload, respPayload)
+	if err != nil {
+		return 0, err
+	}
+
+	// approle token may have been revoked or expired early, login again
+	if statusCode == http.StatusForbidden && vc.token == "" {
+		vc.loginMu.Lock()
+		vc.loginToken = ""
+		vc.loginMu.Unlock()
+
+		token, err = vc.authToken(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return vc.doWithToken(ctx, token, method, path, reqPayload, respPayload)
+	}
+
+	return statusCode, nil
+}
+
+// doWithToken sends a json request to the vault api using the specified token
+func (vc *vaultClient) doWithToken(ctx context.Context, token, method, path string, reqPayload any, respPayload any) (statusCode int, err error) {
+	var body io.Reader
+	if reqPayload != nil {
+		reqBody, err := json.Marshal(reqPayload)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, vc.address+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if reqPayload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if vc.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vc.namespace)
+	}
+
+	resp, err := vc.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if respPayload != nil && resp.StatusCode == http.StatusOK {
+		err = json.Unmarshal(respBody, respPayload)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode vault response (%s)", err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// vault kv field names
+const (
+	vaultFieldKey       = "key"
+	vaultFieldCertchain = "certchain"
+	vaultFieldPfx       = "pfx"
+	// vaultFieldSource is the outputSourceHash the fields were made from
+	vaultFieldSource = "certwarden_source"
+)
+
+// vaultKVOutput is an output backend that writes the key/cert to a vault kv v2 secret
+type vaultKVOutput struct {
+	client     *vaultClient
+	mount      string
+	path       string
+	keyFormat  string
+	pfxCreate  bool
+	pfxOptions pfxOptions
+}
+
+// name implements outputBackend
+func (vko *vaultKVOutput) name() string {
+	return fmt.Sprintf("vault kv secret %s/%s", vko.mount, vko.path)
+}
+
+// sourceHash returns the outputSourceHash of the key/cert and the config the fields
+// are made with
+func (vko *vaultKVOutput) sourceHash(keyPem, certPem []byte) string {
+	outputConfig := fmt.Sprintf("key format: %s, pfx: %t %+v", vko.keyFormat, vko.pfxCreate, vko.pfxOptions)
+	return outputSourceHash(keyPem, certPem, outputConfig)
+}
+
+// status implements outputBackend
+func (vko *vaultKVOutput) status(ctx context.Context, keyPem, certPem []byte) (exists bool, current bool, err error) {
+	var existing struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	statusCode, err := vko.client.do(ctx, http.MethodGet, "/v1/"+vko.mount+"/data/"+vko.path, nil, &existing)
+	if err != nil {
+		return false, false, err
+	}
+
+	if statusCode == http.StatusNotFound {
+		return false, false, nil
+	} else if statusCode != http.StatusOK {
+		return false, false, fmt.Errorf("error reading secret (status: %d)", statusCode)
+	}
+
+	// latest version deleted
+	if existing.Data.Data == nil {
+		return false, false, nil
+	}
+
+	// pfx content changes every time it is made (random salt), so the source hash is
+	// compared instead (which also detects config changes, e.g. the pfx password)
+	current = existing.Data.Data[vaultFieldSource] == vko.sourceHash(keyPem, certPem) &&
+		existing.Data.Data[vaultFieldCertchain] == string(certPem)
+
+	return true, current, nil
+}
+
+// write implements outputBackend
+func (vko *vaultKVOutput) write(ctx context.Context, keyPem, certPem []byte) error {
+	sourceHash := vko.sourceHash(keyPem, certPem)
+
+	keyPem, err := convertKeyPem(keyPem, vko.keyFormat)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]string{
+		vaultFieldKey:       string(keyPem),
+		vaultFieldCertchain: string(certPem),
+		vaultFieldSource:    sourceHash,
+	}
+
+	if vko.pfxCreate {
+		pfx, err := makePfx(keyPem, certPem, vko.pfxOptions)
+		if err != nil {
+			return fmt.Errorf("failed to make pfx (%s)", err)
+		}
+		data[vaultFieldPfx] = base64.StdEncoding.EncodeToString(pfx)
+	}
+
+	// write data
+	statusCode, err := vko.client.do(ctx, http.MethodPost, "/v1/"+vko.mount+"/data/"+vko.path, map[string]any{"data": data}, nil)
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
+		return fmt.Errorf("error writing secret (status: %d)", statusCode)
+	}
+
+	// custom metadata
+	cert, _, err := certPemToCerts(certPem)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := certFingerprint(certPem)
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]any{
+		"custom_metadata": map[string]string{
+			"fingerprint_sha256": fingerprint,
+			"serial":             fmt.Sprintf("%x", cert.SerialNumber),
+			"not_after":          cert.NotAfter.UTC().Format(time.RFC3339),
+		},
+	}
+	statusCode, err = vko.client.do(ctx, http.MethodPost, "/v1/"+vko.mount+"/metadata/"+vko.path, metadata, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write secret metadata (%s)", err)
+	}
+	if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
+		return fmt.Errorf("error writing secret metadata (status: %d)", statusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeVaultKV is an in memory vault kv v2 secrets engine mounted at secret
+type fakeVaultKV struct {
+	mu      sync.Mutex
+	secrets map[string]map[string]string
+}
+
+func (fv *fakeVaultKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "test-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := fv.secrets[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": data}})
+
+		case http.MethodPost:
+			payload := struct {
+				Data map[string]string `json:"data"`
+			}{}
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			fv.secrets[path] = payload.Data
+			_ = json.NewEncoder(w).Encode(map[string]any{})
+		}
+
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultKVOutput(t *testing.T) {
+	fake := &fakeVaultKV{secrets: map[string]map[string]string{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	vko := &vaultKVOutput{
+		client:     &vaultClient{address: srv.URL, httpClient: srv.Client(), token: "test-token"},
+		mount:      "secret",
+		path:       "certs/example",
+		pfxCreate:  true,
+		pfxOptions: pfxOptions{Encoding: "modern", Password: "p@ssword"},
+	}
+	keyPem, certPem := testKeyCert(t, 1)
+	ctx := context.Background()
+
+	status := func(wantExists, wantCurrent bool) {
+		t.Helper()
+
+		exists, current, err := vko.status(ctx, keyPem, certPem)
+		if err != nil {
+			t.Fatalf("status failed: %s", err)
+		}
+		if exists != wantExists || current != wantCurrent {
+			t.Fatalf("status = (%t, %t), want (%t, %t)", exists, current, wantExists, wantCurrent)
+		}
+	}
+
+	status(false, false)
+
+	err := vko.write(ctx, keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	secret := fake.secrets["certs/example"]
+	if secret[vaultFieldKey] != string(keyPem) || secret[vaultFieldCertchain] != string(certPem) || secret[vaultFieldPfx] == "" {
+		t.Fatal("secret fields were not written")
+	}
+	status(true, true)
+
+	// a config change (e.g. pfx password) is not current
+	vko.pfxOptions.Password = "new p@ssword"
+	status(true, false)
+	err = vko.write(ctx, keyPem, certPem)
+	if err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	status(true, true)
+
+	// a new cert is not current
+	keyPem, certPem = testKeyCert(t, 2)
+	status(true, false)
+}